@@ -1,34 +1,86 @@
 package cmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
 	"time"
 
 	"scrawler/scraper/crawl"
 	"scrawler/scraper/fetch"
+	"scrawler/scraper/urlnorm"
 	"scrawler/scraper/util"
+	"scrawler/scraper/warc"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
 var (
-	crawlURL         string
-	crawlURLFile     string
-	crawlUserAgent   string
-	crawlTimeout     int
-	crawlMaxDepth    int
-	crawlMaxPages    int
-	crawlSameHost    bool
-	crawlOutDir      string
-	crawlConcurrency int
-	crawlVerbose     bool
-	crawlSilent      bool
-	crawlExtract     bool
-	crawlFormat      string
-	crawlSaveExtract bool
-	crawlSaveFormat  string
-	crawlDelay       time.Duration
+	crawlURL            string
+	crawlURLFile        string
+	crawlUserAgent      string
+	crawlTimeout        int
+	crawlMaxDepth       int
+	crawlMaxPages       int
+	crawlSameHost       bool
+	crawlOutDir         string
+	crawlConcurrency    int
+	crawlVerbose        bool
+	crawlSilent         bool
+	crawlExtract        bool
+	crawlFormat         string
+	crawlSaveExtract    bool
+	crawlSaveFormat     string
+	crawlDelay          time.Duration
+	crawlMaxRobotsDelay time.Duration
+	crawlStateDir       string
+	crawlRetry          fetch.RetryPolicy
+	crawlResume         string
+	crawlSitemaps       bool
+	crawlSitemapSince   string
+	crawlSaveErrors     bool
+	crawlWARC           bool
+	crawlWARCOnly       bool
+	crawlWARCMaxSize    int64
+	crawlInclude        []string
+	crawlExclude        []string
+	crawlScope          []string
+	crawlSortQuery      bool
+	crawlStripParams    []string
+	crawlKeepTracking   bool
+	crawlLowercasePaths bool
+	crawlTrailingSlash  string
+	crawlIgnoreCanon    bool
+	crawlIgnoreMeta     bool
+	crawlMaxRedirects   int
+	crawlMaxBodySize    int64
+	crawlIncremental    bool
+	crawlCookieFile     string
+	crawlCookies        []string
+	crawlHeaders        []string
+	crawlHeaderFile     string
+	crawlUser           string
+	crawlBearer         string
+	crawlProxies        []string
+	crawlProxyFile      string
+	crawlProxyRotation  string
+	crawlProxyFailures  int
+	crawlProxyCooldown  time.Duration
+	crawlTransport      fetch.TransportConfig
+	crawlMaxPerHost     int
+	crawlStrategy       string
+	crawlScores         []string
+	crawlScoring        = crawl.DefaultScoring
+	crawlTransportFile  string
+	crawlResolve        []string
 )
 
 var crawlCmd = &cobra.Command{
@@ -37,12 +89,133 @@ var crawlCmd = &cobra.Command{
 	Long: `Crawl a website starting from the specified URL.
 Supports concurrent crawling, depth control, and various output options.`,
 	Example: `  scraper crawl -u https://example.com -d 2 -o json
-  scraper crawl -u https://example.com --max-pages 100 --concurrency 5`,
+  scraper crawl -u https://example.com --max-pages 100 --concurrency 5
+  scraper crawl -u https://example.com --state-dir state/example
+  scraper crawl --resume state/example
+  scraper crawl -u https://example.com --sitemaps --sitemap-since 2024-01-01
+  scraper crawl -u https://example.com --warc-only --warc-max-size 500000000
+  scraper crawl -u https://example.com/docs/ --scope prefix --exclude 're:[?&]sort='
+  scraper crawl -u https://example.com --scope domain --include '/blog/**'
+  scraper crawl -u https://example.com -o mirror/example --incremental
+  scraper crawl -u https://intranet.example.com --cookies-file cookies.txt --cookie 'lang=en'
+  scraper crawl -u https://intranet.example.com --bearer "$TOKEN" -H 'Accept-Language: de'
+  scraper crawl -u https://example.com --proxy-file proxies.txt --proxy-rotation sticky
+  scraper crawl -u https://staging.example.com --ca-file ca.pem --resolve staging.example.com:10.0.0.5
+  scraper crawl -u https://example.com --max-pages 500 --strategy best-first --score '/blog/**=5' --score 're:/tag/=-3'`,
 	Run: func(cmd *cobra.Command, args []string) {
 		color.Cyan("🚀 Starting crawler...")
 
 		util.ConfigureLogging(crawlVerbose, crawlSilent)
-		fetch.SetMinDelay(crawlDelay)
+
+		// The first Ctrl-C drains in-flight fetches and flushes state; a second
+		// one exits immediately.
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		context.AfterFunc(ctx, stop)
+
+		headers, err := headerProfile()
+		if err != nil {
+			color.Red("✘ Error: %s", err)
+			os.Exit(1)
+		}
+		scoring, err := scoringRules(cmd)
+		if err != nil {
+			color.Red("✘ Error: %s", err)
+			os.Exit(1)
+		}
+
+		if crawlResume != "" {
+			copts, err := crawl.LoadStateOptions(crawlResume)
+			if err != nil {
+				color.Red("✘ Error loading crawl state: %s", err)
+				log.Fatal(err)
+			}
+			copts.Resume = true
+			// a resumed crawl may raise its page budget or worker count
+			if cmd.Flags().Changed("max-pages") {
+				copts.MaxPages = crawlMaxPages
+			}
+			if cmd.Flags().Changed("concurrency") {
+				copts.Concurrency = crawlConcurrency
+			}
+			if cmd.Flags().Changed("max-per-host") {
+				copts.MaxPerHost = maxPerHost()
+			}
+			if cmd.Flags().Changed("strategy") {
+				copts.Strategy = crawlStrategy
+			}
+			if scoring != nil {
+				copts.Scoring = scoring
+			}
+			if cmd.Flags().Changed("delay") {
+				copts.MinDelay = crawlDelay
+			}
+			if cmd.Flags().Changed("max-robots-delay") {
+				copts.MaxRobotsDelay = maxRobotsDelay()
+			}
+			for _, name := range []string{"retries", "retry-base-delay", "retry-max-delay", "breaker-threshold", "breaker-cooldown"} {
+				if cmd.Flags().Changed(name) {
+					copts.Retry = &crawlRetry
+				}
+			}
+			// cookies given on the command line are not saved with the state
+			if cmd.Flags().Changed("cookies-file") {
+				copts.CookieFile = crawlCookieFile
+			}
+			copts.Cookies = crawlCookies
+			if cmd.Flags().Changed("header-file") {
+				copts.HeaderFile = crawlHeaderFile
+			}
+			copts.Headers, copts.BasicAuth, copts.BearerToken = headers, crawlUser, crawlBearer
+			copts.Proxies = crawlProxies
+			if cmd.Flags().Changed("proxy-file") {
+				copts.ProxyFile = crawlProxyFile
+			}
+			if cmd.Flags().Changed("proxy-rotation") {
+				copts.ProxyRotation = crawlProxyRotation
+			}
+			if cmd.Flags().Changed("proxy-max-failures") {
+				copts.ProxyMaxFailures = crawlProxyFailures
+			}
+			if cmd.Flags().Changed("proxy-cooldown") {
+				copts.ProxyCooldown = crawlProxyCooldown
+			}
+			copts.Transport, err = transportConfig(cmd, copts.Transport)
+			if err != nil {
+				color.Red("✘ Error: %s", err)
+				os.Exit(1)
+			}
+			color.Yellow("🌐 Resuming: %s", copts.StartURL)
+			runCrawl(ctx, copts)
+			color.Cyan("🎉 Crawling completed!")
+			return
+		}
+		if crawlURL == "" && crawlURLFile == "" {
+			color.Red("✘ Error: --url, --url-file or --resume is required")
+			os.Exit(1)
+		}
+
+		var sitemapSince time.Time
+		if crawlSitemapSince != "" {
+			t, err := time.Parse("2006-01-02", crawlSitemapSince)
+			if err != nil {
+				color.Red("✘ Error: invalid --sitemap-since %q, expected YYYY-MM-DD", crawlSitemapSince)
+				os.Exit(1)
+			}
+			sitemapSince = t
+		}
+
+		canon, err := canonicalRules()
+		if err != nil {
+			color.Red("✘ Error: %s", err)
+			os.Exit(1)
+		}
+
+		transport, err := transportConfig(cmd, fetch.TransportConfig{})
+		if err != nil {
+			color.Red("✘ Error: %s", err)
+			os.Exit(1)
+		}
 
 		urls, err := util.GatherURLs(crawlURL, crawlURLFile)
 		if err != nil {
@@ -52,35 +225,57 @@ Supports concurrent crawling, depth control, and various output options.`,
 
 		color.Green("✓ Found %d URLs to crawl", len(urls))
 
-		for _, u := range urls {
+		for i, u := range urls {
 			color.Yellow("🌐 Crawling: %s", u)
 
 			copts := crawl.Options{
-				StartURL:          u,
-				UserAgent:         crawlUserAgent,
-				TimeoutSecs:       crawlTimeout,
-				MaxDepth:          crawlMaxDepth,
-				MaxPages:          crawlMaxPages,
-				SameHostOnly:      crawlSameHost,
-				OutDir:            crawlOutDir,
-				Concurrency:       crawlConcurrency,
-				SaveExtract:       crawlSaveExtract,
-				ExtractSaveFormat: crawlSaveFormat,
-			}
-
-			var err error
-			if copts.Concurrency <= 1 {
-				color.Blue("🔄 Using sequential crawling")
-				err = crawl.Crawl(copts)
-			} else {
-				color.Blue("🔄 Using concurrent crawling with %d workers", copts.Concurrency)
-				err = crawl.CrawlConcurrent(copts)
+				StartURL:           u,
+				UserAgent:          crawlUserAgent,
+				TimeoutSecs:        crawlTimeout,
+				MaxDepth:           crawlMaxDepth,
+				MaxPages:           crawlMaxPages,
+				SameHostOnly:       crawlSameHost,
+				OutDir:             crawlOutDir,
+				Concurrency:        crawlConcurrency,
+				MaxPerHost:         maxPerHost(),
+				Strategy:           crawlStrategy,
+				Scoring:            scoring,
+				SaveExtract:        crawlSaveExtract,
+				ExtractSaveFormat:  crawlSaveFormat,
+				StateDir:           stateDirFor(i, len(urls)),
+				Sitemaps:           crawlSitemaps,
+				SitemapSince:       sitemapSince,
+				SaveErrors:         crawlSaveErrors,
+				WARC:               crawlWARC,
+				WARCOnly:           crawlWARCOnly,
+				WARCMaxSize:        crawlWARCMaxSize,
+				Include:            crawlInclude,
+				Exclude:            crawlExclude,
+				Scope:              crawlScope,
+				Canonical:          canon,
+				IgnoreRelCanonical: crawlIgnoreCanon,
+				IgnoreRobotsMeta:   crawlIgnoreMeta,
+				MaxRedirects:       maxRedirects(),
+				MaxBodySize:        crawlMaxBodySize,
+				Incremental:        crawlIncremental,
+				CookieFile:         crawlCookieFile,
+				Cookies:            crawlCookies,
+				HeaderFile:         crawlHeaderFile,
+				Headers:            headers,
+				BasicAuth:          crawlUser,
+				BearerToken:        crawlBearer,
+				Proxies:            crawlProxies,
+				ProxyFile:          crawlProxyFile,
+				ProxyRotation:      crawlProxyRotation,
+				ProxyMaxFailures:   crawlProxyFailures,
+				ProxyCooldown:      crawlProxyCooldown,
+				Transport:          transport,
+				MinDelay:           crawlDelay,
+				MaxRobotsDelay:     maxRobotsDelay(),
+				Retry:              &crawlRetry,
 			}
-
-			if err != nil {
-				color.Red("✘ Error during crawling: %s", err)
-			} else {
-				color.Green("✓ Successfully crawled: %s", u)
+			if !runCrawl(ctx, copts) {
+				break
 			}
 		}
 
@@ -88,6 +283,212 @@ Supports concurrent crawling, depth control, and various output options.`,
 	},
 }
 
+// runCrawl runs one crawl and reports whether the next one may start; it
+// returns false once the crawl was interrupted.
+func runCrawl(ctx context.Context, copts crawl.Options) bool {
+	if copts.Concurrency <= 1 {
+		color.Blue("🔄 Using sequential crawling")
+	} else {
+		color.Blue("🔄 Using concurrent crawling with %d workers", copts.Concurrency)
+	}
+	copts.OnEvent = printEvent
+	c, err := crawl.NewCrawler(copts)
+	if err == nil {
+		err = c.Run(ctx)
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		if copts.StateDir != "" {
+			color.Yellow("⏹ Interrupted; continue with: scraper crawl --resume %s", copts.StateDir)
+		} else {
+			color.Yellow("⏹ Interrupted: %s", copts.StartURL)
+		}
+		return false
+	case err != nil:
+		color.Red("✘ Error during crawling: %s", err)
+	default:
+		color.Green("✓ Successfully crawled: %s", copts.StartURL)
+	}
+	return true
+}
+
+// printEvent reports crawl progress on the terminal.
+func printEvent(ev crawl.Event) {
+	switch ev := ev.(type) {
+	case crawl.PageFetched:
+		if ev.Record.Status == "unchanged" {
+			color.Green("= Unchanged (%d): %s", ev.Pages, ev.Record.URL)
+		} else {
+			color.Green("✓ Saved (%d): %s", ev.Pages, ev.Record.URL)
+		}
+		if ev.Record.Truncated {
+			color.Yellow("⚠ Truncated at %d bytes: %s", ev.Record.Bytes, ev.Record.URL)
+		}
+	case crawl.SitemapsSeeded:
+		color.Green("✓ Seeded %d URL(s) from sitemaps", ev.URLs)
+	case crawl.Error:
+		var he *fetch.HTTPStatusError
+		switch {
+		case errors.As(ev.Err, &he):
+			color.Yellow("⚠ HTTP %d: %s", he.StatusCode, ev.URL)
+		case ev.URL != "":
+			color.Yellow("⚠ Warning: %s: %v", ev.URL, ev.Err)
+		default:
+			color.Yellow("⚠ Warning: %v", ev.Err)
+		}
+	case crawl.Done:
+		if errors.Is(ev.Err, context.Canceled) {
+			color.Yellow("⏹ Crawl interrupted after %d page(s)", ev.Pages)
+		} else if ev.Err == nil {
+			color.Cyan("🎉 Crawl complete. Fetched %d page(s)", ev.Pages)
+		}
+		if r := ev.Report; r != nil {
+			color.Cyan("📊 %d new, %d changed, %d unchanged, %d disappeared (see %s)", len(r.New), len(r.Changed), len(r.Unchanged), len(r.Disappeared), crawl.ReportFile)
+		}
+	}
+}
+
+// headerProfile builds the headers sent to every host from the --header
+// flags, echoing them with credentials redacted.
+func headerProfile() ([]fetch.HeaderProfile, error) {
+	if len(crawlHeaders) == 0 {
+		return nil, nil
+	}
+	h := make(http.Header)
+	for _, line := range crawlHeaders {
+		name, value, err := fetch.ParseHeader(line)
+		if err != nil {
+			return nil, err
+		}
+		h.Add(name, value)
+		color.Blue("📨 Header: %s", fetch.RedactHeaderLine(name+": "+value))
+	}
+	return []fetch.HeaderProfile{{Header: h}}, nil
+}
+
+// transportConfig applies --transport-config and the transport flags given on
+// the command line, in that order, to base.
+func transportConfig(cmd *cobra.Command, base fetch.TransportConfig) (fetch.TransportConfig, error) {
+	cfg := base
+	if crawlTransportFile != "" {
+		c, err := fetch.LoadTransportConfig(crawlTransportFile)
+		if err != nil {
+			return cfg, err
+		}
+		cfg = c
+	}
+	flags := map[string]func(){
+		"dial-timeout":            func() { cfg.DialTimeout = crawlTransport.DialTimeout },
+		"tls-handshake-timeout":   func() { cfg.TLSHandshakeTimeout = crawlTransport.TLSHandshakeTimeout },
+		"response-header-timeout": func() { cfg.ResponseHeaderTimeout = crawlTransport.ResponseHeaderTimeout },
+		"idle-conn-timeout":       func() { cfg.IdleConnTimeout = crawlTransport.IdleConnTimeout },
+		"max-idle-conns":          func() { cfg.MaxIdleConns = crawlTransport.MaxIdleConns },
+		"max-idle-conns-per-host": func() { cfg.MaxIdleConnsPerHost = crawlTransport.MaxIdleConnsPerHost },
+		"max-conns-per-host":      func() { cfg.MaxConnsPerHost = crawlTransport.MaxConnsPerHost },
+		"no-http2":                func() { cfg.DisableHTTP2 = crawlTransport.DisableHTTP2 },
+		"ca-file":                 func() { cfg.CAFile = crawlTransport.CAFile },
+		"insecure":                func() { cfg.InsecureSkipVerify = crawlTransport.InsecureSkipVerify },
+		"dns-cache-ttl":           func() { cfg.DNSCacheTTL = crawlTransport.DNSCacheTTL },
+	}
+	for name, apply := range flags {
+		if cmd.Flags().Changed(name) {
+			apply()
+		}
+	}
+	if len(crawlResolve) > 0 {
+		cfg.Hosts = maps.Clone(cfg.Hosts)
+		if cfg.Hosts == nil {
+			cfg.Hosts = make(map[string]string)
+		}
+		for _, r := range crawlResolve {
+			host, ip, err := fetch.ParseHostOverride(r)
+			if err != nil {
+				return cfg, err
+			}
+			cfg.Hosts[host] = ip
+		}
+	}
+	if cfg.InsecureSkipVerify {
+		color.Yellow("⚠ TLS certificates are not verified")
+	}
+	return cfg, nil
+}
+
+// maxRedirects maps the flag, where 0 follows no redirects, to crawl.Options.
+func maxRedirects() int {
+	if crawlMaxRedirects == 0 {
+		return -1
+	}
+	return crawlMaxRedirects
+}
+
+// scoringRules builds the best-first scoring from the --score flags, or
+// returns nil when none were given so that crawl.DefaultScoring applies.
+func scoringRules(cmd *cobra.Command) (*crawl.Scoring, error) {
+	changed := len(crawlScores) > 0
+	for _, name := range []string{"score-depth", "score-inlinks", "score-sitemap"} {
+		changed = changed || cmd.Flags().Changed(name)
+	}
+	if !changed {
+		return nil, nil
+	}
+	scoring := crawlScoring
+	for _, s := range crawlScores {
+		pw, err := crawl.ParsePatternWeight(s)
+		if err != nil {
+			return nil, err
+		}
+		scoring.Patterns = append(scoring.Patterns, pw)
+	}
+	return &scoring, nil
+}
+
+// maxPerHost maps the flag, where 0 sets no per-host limit, to crawl.Options.
+func maxPerHost() int {
+	if crawlMaxPerHost == 0 {
+		return -1
+	}
+	return crawlMaxPerHost
+}
+
+// maxRobotsDelay maps the flag, where 0 ignores robots.txt delays, to crawl.Options.
+func maxRobotsDelay() time.Duration {
+	if crawlMaxRobotsDelay == 0 {
+		return -1
+	}
+	return crawlMaxRobotsDelay
+}
+
+// canonicalRules builds the URL normalization from the flags.
+func canonicalRules() (*urlnorm.Rules, error) {
+	switch crawlTrailingSlash {
+	case "keep", "add", "strip":
+	default:
+		return nil, fmt.Errorf("invalid --trailing-slash %q, expected keep, add or strip", crawlTrailingSlash)
+	}
+	rules := urlnorm.Default
+	rules.SortQuery = crawlSortQuery
+	rules.LowercasePath = crawlLowercasePaths
+	if crawlTrailingSlash != "keep" {
+		rules.TrailingSlash = crawlTrailingSlash
+	}
+	rules.StripParams = nil
+	if !crawlKeepTracking {
+		rules.StripParams = append(rules.StripParams, urlnorm.TrackingParams...)
+	}
+	rules.StripParams = append(rules.StripParams, crawlStripParams...)
+	return &rules, nil
+}
+
+// stateDirFor gives each start URL its own state directory when several are crawled.
+func stateDirFor(i, n int) string {
+	if crawlStateDir == "" || n == 1 {
+		return crawlStateDir
+	}
+	return filepath.Join(crawlStateDir, fmt.Sprintf("%03d", i))
+}
+
 func init() {
 	rootCmd.AddCommand(crawlCmd)
 
@@ -101,14 +502,68 @@ func init() {
 	crawlCmd.Flags().BoolVarP(&crawlSameHost, "same-host", "", true, "Restrict crawling to same host only")
 	crawlCmd.Flags().StringVarP(&crawlOutDir, "out", "o", "out", "Output directory for crawled data")
 	crawlCmd.Flags().IntVarP(&crawlConcurrency, "concurrency", "", 1, "Number of concurrent workers")
+	crawlCmd.Flags().IntVarP(&crawlMaxPerHost, "max-per-host", "", crawl.DefaultMaxPerHost, "Concurrent requests to one host (0 for no limit)")
+	crawlCmd.Flags().StringVarP(&crawlStrategy, "strategy", "", crawl.StrategyBFS, "Crawl order: bfs|dfs|best-first|random")
+	crawlCmd.Flags().StringArrayVarP(&crawlScores, "score", "", nil, "Best-first weight for URLs matching a glob or regex (re:...), as pattern=weight; repeatable")
+	crawlCmd.Flags().Float64VarP(&crawlScoring.DepthWeight, "score-depth", "", crawl.DefaultScoring.DepthWeight, "Best-first weight per link level")
+	crawlCmd.Flags().Float64VarP(&crawlScoring.InLinkWeight, "score-inlinks", "", crawl.DefaultScoring.InLinkWeight, "Best-first weight per link found to a URL")
+	crawlCmd.Flags().Float64VarP(&crawlScoring.SitemapWeight, "score-sitemap", "", crawl.DefaultScoring.SitemapWeight, "Best-first weight of a URL's sitemap priority")
 	crawlCmd.Flags().BoolVarP(&crawlVerbose, "verbose", "v", false, "Enable verbose logging")
 	crawlCmd.Flags().BoolVarP(&crawlSilent, "silent", "", false, "Disable all logging")
 	crawlCmd.Flags().BoolVarP(&crawlExtract, "extract", "", false, "Extract signals during crawl")
 	crawlCmd.Flags().StringVarP(&crawlFormat, "format", "", "json", "Output format: json|md|txt")
 	crawlCmd.Flags().BoolVarP(&crawlSaveExtract, "save-extract", "", false, "Save extraction results during crawl")
 	crawlCmd.Flags().StringVarP(&crawlSaveFormat, "extract-save-format", "", "json", "Format for saved extractions")
+	crawlCmd.Flags().BoolVarP(&crawlSaveErrors, "save-errors", "", false, "Keep non-2xx error pages under <out>/errors")
+	crawlCmd.Flags().BoolVarP(&crawlWARC, "warc", "", false, "Also archive responses as WARC 1.1 files under <out>/warc")
+	crawlCmd.Flags().BoolVarP(&crawlWARCOnly, "warc-only", "", false, "Archive to WARC files instead of the mirrored HTML tree")
+	crawlCmd.Flags().Int64VarP(&crawlWARCMaxSize, "warc-max-size", "", warc.DefaultMaxSize, "Start a new WARC file once one reaches this many bytes")
+	crawlCmd.Flags().StringArrayVarP(&crawlInclude, "include", "", nil, "Only crawl URLs matching this glob (/docs/**) or regex (re:...); repeatable")
+	crawlCmd.Flags().StringArrayVarP(&crawlExclude, "exclude", "", nil, "Skip URLs matching this glob or regex (re:...); repeatable")
+	crawlCmd.Flags().StringArrayVarP(&crawlScope, "scope", "", nil, "Scope rule: host, host:H, host:*.H, domain, domain:D, any, prefix, prefix:/path/; repeatable")
+	crawlCmd.Flags().BoolVarP(&crawlSortQuery, "sort-query", "", true, "Treat URLs differing only in query parameter order as the same page")
+	crawlCmd.Flags().StringArrayVarP(&crawlStripParams, "strip-param", "", nil, "Ignore this query parameter when comparing URLs (prefix* allowed); repeatable")
+	crawlCmd.Flags().BoolVarP(&crawlKeepTracking, "keep-tracking-params", "", false, "Do not ignore utm_*, fbclid, gclid and similar parameters")
+	crawlCmd.Flags().BoolVarP(&crawlLowercasePaths, "lowercase-paths", "", false, "Compare URL paths case-insensitively")
+	crawlCmd.Flags().StringVarP(&crawlTrailingSlash, "trailing-slash", "", "keep", "Trailing slash policy when comparing URLs: keep|add|strip")
+	crawlCmd.Flags().BoolVarP(&crawlIgnoreCanon, "ignore-canonical", "", false, "Ignore <link rel=\"canonical\"> when deduplicating pages")
+	crawlCmd.Flags().BoolVarP(&crawlIgnoreMeta, "ignore-robots-meta", "", false, "Ignore noindex/nofollow/noarchive in meta robots, X-Robots-Tag and rel=nofollow links")
+	crawlCmd.Flags().IntVarP(&crawlMaxRedirects, "max-redirects", "", fetch.DefaultMaxRedirects, "Redirects to follow per URL (0 follows none)")
+	crawlCmd.Flags().Int64VarP(&crawlMaxBodySize, "max-body-size", "", 10<<20, "Truncate pages larger than this many bytes (0 for no limit)")
+	crawlCmd.Flags().StringVarP(&crawlCookieFile, "cookies-file", "", "", "Netscape cookies.txt file to load cookies from and save them back to after the crawl")
+	crawlCmd.Flags().StringArrayVarP(&crawlCookies, "cookie", "", nil, "Cookie to send, e.g. 'session=abc' or 'session=abc; Domain=example.com'; repeatable")
+	crawlCmd.Flags().StringArrayVarP(&crawlHeaders, "header", "H", nil, "Extra request header for every host, e.g. 'Accept-Language: de'; repeatable")
+	crawlCmd.Flags().StringVarP(&crawlHeaderFile, "header-file", "", "", "JSON file of per-host headers, e.g. {\"*.example.com\": {\"X-Api-Key\": \"...\"}}")
+	crawlCmd.Flags().StringVarP(&crawlUser, "user", "", "", "Basic auth credentials as user:password, sent to the start URL's host only")
+	crawlCmd.Flags().StringVarP(&crawlBearer, "bearer", "", "", "Bearer token sent to the start URL's host only")
+	crawlCmd.Flags().StringArrayVarP(&crawlProxies, "proxy", "", nil, "Proxy to send requests through: http://, https:// or socks5://[user:pass@]host:port; repeatable")
+	crawlCmd.Flags().StringVarP(&crawlProxyFile, "proxy-file", "", "", "File listing one proxy URL per line")
+	crawlCmd.Flags().StringVarP(&crawlProxyRotation, "proxy-rotation", "", fetch.RotateRoundRobin, "How requests pick a proxy: round-robin|sticky (one proxy per host)")
+	crawlCmd.Flags().IntVarP(&crawlProxyFailures, "proxy-max-failures", "", fetch.DefaultProxyMaxFailures, "Consecutive failures before a proxy is left out")
+	crawlCmd.Flags().DurationVarP(&crawlProxyCooldown, "proxy-cooldown", "", fetch.DefaultProxyCooldown, "How long a failing proxy is left out")
+	crawlCmd.Flags().StringVarP(&crawlTransportFile, "transport-config", "", "", "JSON file of transport settings, e.g. {\"dial_timeout\": \"5s\", \"disable_http2\": true}; flags override it")
+	crawlCmd.Flags().DurationVarP(&crawlTransport.DialTimeout, "dial-timeout", "", 30*time.Second, "TCP connect timeout")
+	crawlCmd.Flags().DurationVarP(&crawlTransport.TLSHandshakeTimeout, "tls-handshake-timeout", "", 10*time.Second, "TLS handshake timeout")
+	crawlCmd.Flags().DurationVarP(&crawlTransport.ResponseHeaderTimeout, "response-header-timeout", "", 0, "Time to wait for response headers after sending a request (0 for no limit besides --timeout)")
+	crawlCmd.Flags().DurationVarP(&crawlTransport.IdleConnTimeout, "idle-conn-timeout", "", 90*time.Second, "How long idle connections are kept open")
+	crawlCmd.Flags().IntVarP(&crawlTransport.MaxIdleConns, "max-idle-conns", "", 100, "Idle connections kept across all hosts")
+	crawlCmd.Flags().IntVarP(&crawlTransport.MaxIdleConnsPerHost, "max-idle-conns-per-host", "", fetch.DefaultMaxIdleConnsPerHost, "Idle connections kept per host")
+	crawlCmd.Flags().IntVarP(&crawlTransport.MaxConnsPerHost, "max-conns-per-host", "", 0, "Connections per host (0 for no limit)")
+	crawlCmd.Flags().BoolVarP(&crawlTransport.DisableHTTP2, "no-http2", "", false, "Speak HTTP/1.1 only")
+	crawlCmd.Flags().StringVarP(&crawlTransport.CAFile, "ca-file", "", "", "PEM file of extra certificate authorities to trust")
+	crawlCmd.Flags().BoolVarP(&crawlTransport.InsecureSkipVerify, "insecure", "", false, "Skip TLS certificate verification (staging hosts only)")
+	crawlCmd.Flags().DurationVarP(&crawlTransport.DNSCacheTTL, "dns-cache-ttl", "", 0, "Cache DNS lookups for this long (0 disables)")
+	crawlCmd.Flags().StringArrayVarP(&crawlResolve, "resolve", "", nil, "Connect to this IP for a host, as host:ip; repeatable")
+	crawlCmd.Flags().BoolVarP(&crawlIncremental, "incremental", "", false, "Re-crawl the output directory, skipping pages unchanged since the last run (ETag/Last-Modified)")
 	crawlCmd.Flags().DurationVarP(&crawlDelay, "delay", "", 0, "Minimum delay between requests (e.g., 1s)")
-
-	// Mark required flags
-	crawlCmd.MarkFlagRequired("url")
+	crawlCmd.Flags().DurationVarP(&crawlMaxRobotsDelay, "max-robots-delay", "", 10*time.Second, "Cap on robots.txt Crawl-delay/Request-rate per host (0 ignores them)")
+	crawlCmd.Flags().IntVarP(&crawlRetry.MaxRetries, "retries", "", fetch.DefaultRetryPolicy.MaxRetries, "Retries for network errors and 429/5xx responses")
+	crawlCmd.Flags().DurationVarP(&crawlRetry.BaseDelay, "retry-base-delay", "", fetch.DefaultRetryPolicy.BaseDelay, "Initial retry backoff, doubled on each retry")
+	crawlCmd.Flags().DurationVarP(&crawlRetry.MaxDelay, "retry-max-delay", "", fetch.DefaultRetryPolicy.MaxDelay, "Maximum retry backoff, Retry-After and per-host slowdown")
+	crawlCmd.Flags().IntVarP(&crawlRetry.BreakerThreshold, "breaker-threshold", "", fetch.DefaultRetryPolicy.BreakerThreshold, "Consecutive failures before a host is paused (0 disables)")
+	crawlCmd.Flags().DurationVarP(&crawlRetry.BreakerCooldown, "breaker-cooldown", "", fetch.DefaultRetryPolicy.BreakerCooldown, "How long a failing host is paused")
+	crawlCmd.Flags().StringVarP(&crawlStateDir, "state-dir", "", "", "Directory to checkpoint crawl state into for later --resume")
+	crawlCmd.Flags().BoolVarP(&crawlSitemaps, "sitemaps", "", false, "Seed the crawl from robots.txt sitemaps and /sitemap.xml")
+	crawlCmd.Flags().StringVarP(&crawlSitemapSince, "sitemap-since", "", "", "Only seed sitemap URLs with <lastmod> on or after this date (YYYY-MM-DD)")
+	crawlCmd.Flags().StringVarP(&crawlResume, "resume", "", "", "Resume the crawl checkpointed in this state directory")
 }
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"scrawler/scraper/diff"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var diffFormat string
+
+var diffCmd = &cobra.Command{
+	Use:   "diff <crawlA> <crawlB>",
+	Short: "Compare two crawls",
+	Long: `Compare two crawl output directories, or their manifest files.
+Lists added and removed URLs, pages whose content changed and, for those, the
+lines that changed in the title, headings, paragraphs and links.`,
+	Example: `  scraper diff out/monday out/tuesday
+  scraper diff out/monday/manifest.jsonl out/tuesday -f markdown > changes.md
+  scraper diff out/monday out/tuesday -f json`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		switch diffFormat {
+		case "text", "json", "markdown", "md":
+		default:
+			return fmt.Errorf("invalid --format %q, expected text, json or markdown", diffFormat)
+		}
+		a, err := diff.Load(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := diff.Load(args[1])
+		if err != nil {
+			return err
+		}
+		res := diff.Compare(a, b)
+
+		switch diffFormat {
+		case "json":
+			data, err := diff.RenderJSON(res)
+			if err != nil {
+				return err
+			}
+			os.Stdout.Write(append(data, '\n'))
+		case "markdown", "md":
+			fmt.Print(diff.RenderMarkdown(res))
+		default:
+			printDiff(res)
+		}
+		return nil
+	},
+}
+
+// printDiff writes the terminal report.
+func printDiff(res *diff.Result) {
+	color.Cyan("📊 %s → %s: %d added, %d removed, %d changed, %d unchanged",
+		res.A, res.B, len(res.Added), len(res.Removed), len(res.Changed), res.Unchanged)
+	for _, u := range res.Added {
+		color.Green("+ %s", u)
+	}
+	for _, u := range res.Removed {
+		color.Red("- %s", u)
+	}
+	for _, p := range res.Changed {
+		color.Yellow("~ %s", p.URL)
+		switch {
+		case p.Note != "":
+			fmt.Printf("    (%s)\n", p.Note)
+		case len(p.Fields) == 0:
+			fmt.Println("    (markup only)")
+		}
+		for _, f := range p.Fields {
+			color.Blue("  %s:", f.Name)
+			for _, l := range f.Lines {
+				if l.Op == "+" {
+					color.Green("    + %s", l.Text)
+				} else {
+					color.Red("    - %s", l.Text)
+				}
+			}
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+
+	diffCmd.Flags().StringVarP(&diffFormat, "format", "f", "text", "Output format: text|json|markdown")
+}
@@ -0,0 +1,133 @@
+package crawl
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"scrawler/scraper/fetch"
+)
+
+// ReportFile is where an incremental crawl writes its Report, in the output
+// directory. Each completed run replaces it.
+const ReportFile = "report.json"
+
+// Report compares the pages of an incremental crawl with the prior runs
+// recorded in the manifest. Unchanged pages are those the server answered 304
+// for or whose content hash is the same; Disappeared lists URLs kept before
+// that were not kept this time, because they failed, went out of scope or
+// were not reached.
+type Report struct {
+	New         []string `json:"new"`
+	Changed     []string `json:"changed"`
+	Unchanged   []string `json:"unchanged"`
+	Disappeared []string `json:"disappeared"`
+}
+
+// How a URL fetched in an incremental crawl compares with the prior run.
+const (
+	changeNew       = "new"
+	changeChanged   = "changed"
+	changeUnchanged = "unchanged"
+)
+
+// changeSet classifies the manifest records of an incremental crawl against
+// the latest record of each URL from earlier runs. It is fed by manifestLog,
+// under its lock.
+type changeSet struct {
+	prior   map[string]ManifestRecord
+	current map[string]string // URL to change, "" when the URL was not kept
+	resumed func(*url.URL) bool
+}
+
+// loadChanges reads the prior records from an existing manifest, if any.
+// resumed reports URLs visited before a resume; they have no record in this
+// run but have not disappeared.
+func loadChanges(outDir string, resumed func(*url.URL) bool) (*changeSet, error) {
+	c := &changeSet{prior: make(map[string]ManifestRecord), current: make(map[string]string), resumed: resumed}
+	recs, err := ReadManifest(filepath.Join(outDir, ManifestFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	for _, rec := range recs {
+		c.prior[rec.URL] = rec
+	}
+	return c, nil
+}
+
+// kept reports whether a record's page has a copy in the output.
+func kept(status string) bool {
+	return status == statusSaved || status == statusUnchanged
+}
+
+// validators returns what to make the request for u conditional on: the
+// prior response's ETag and Last-Modified, provided the copy saved from it
+// is still there to stand in for a 304 body.
+func (c *changeSet) validators(outDir, u string) (fetch.Validators, ManifestRecord) {
+	p, ok := c.prior[u]
+	if !ok || !kept(p.Status) || p.SavedPath == "" {
+		return fetch.Validators{}, p
+	}
+	if _, err := os.Stat(filepath.Join(outDir, filepath.FromSlash(p.SavedPath))); err != nil {
+		return fetch.Validators{}, p
+	}
+	return fetch.Validators{ETag: p.ETag, LastModified: p.LastModified}, p
+}
+
+func (c *changeSet) observe(rec ManifestRecord) {
+	if !kept(rec.Status) {
+		if _, ok := c.current[rec.URL]; !ok {
+			c.current[rec.URL] = ""
+		}
+		return
+	}
+	p, ok := c.prior[rec.URL]
+	switch {
+	case !ok || !kept(p.Status):
+		c.current[rec.URL] = changeNew
+	case rec.Status == statusUnchanged || rec.SHA256 == p.SHA256:
+		c.current[rec.URL] = changeUnchanged
+	default:
+		c.current[rec.URL] = changeChanged
+	}
+}
+
+func (c *changeSet) report() *Report {
+	rep := &Report{New: []string{}, Changed: []string{}, Unchanged: []string{}, Disappeared: []string{}}
+	for u, change := range c.current {
+		switch change {
+		case changeNew:
+			rep.New = append(rep.New, u)
+		case changeChanged:
+			rep.Changed = append(rep.Changed, u)
+		case changeUnchanged:
+			rep.Unchanged = append(rep.Unchanged, u)
+		}
+	}
+	for u, p := range c.prior {
+		if !kept(p.Status) || c.current[u] != "" {
+			continue
+		}
+		if _, seen := c.current[u]; !seen && c.resumed != nil {
+			if pu, err := url.Parse(u); err == nil && c.resumed(pu) {
+				continue
+			}
+		}
+		rep.Disappeared = append(rep.Disappeared, u)
+	}
+	for _, list := range [][]string{rep.New, rep.Changed, rep.Unchanged, rep.Disappeared} {
+		slices.Sort(list)
+	}
+	return rep
+}
+
+func writeReport(outDir string, rep *Report) error {
+	data, err := json.MarshalIndent(rep, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(outDir, ReportFile), append(data, '\n'))
+}
@@ -1,21 +1,39 @@
 package crawl
 
 import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"path/filepath"
-	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"scrawler/scraper/fetch"
 	"scrawler/scraper/output"
 	"scrawler/scraper/parse"
+	"scrawler/scraper/sitemap"
+	"scrawler/scraper/urlnorm"
+	"scrawler/scraper/warc"
 
 	"github.com/PuerkitoBio/goquery"
-	"github.com/fatih/color"
 )
 
+// DefaultMaxPerHost is the per-host limit on concurrent fetches when
+// Options.MaxPerHost is 0.
+const DefaultMaxPerHost = 4
+
 type Options struct {
 	StartURL          string
 	UserAgent         string
@@ -27,232 +45,879 @@ type Options struct {
 	Concurrency       int
 	SaveExtract       bool
 	ExtractSaveFormat string
+	// MaxPerHost limits the fetches in flight to one host when crawling
+	// concurrently; 0 means DefaultMaxPerHost and a negative value sets no
+	// limit. Hosts with queued URLs take turns.
+	MaxPerHost int
+	// StateDir, when set, checkpoints the frontier, visited set, content hashes
+	// and per-URL status so the crawl can be resumed with Resume.
+	StateDir string
+	Resume   bool
+	// Sitemaps seeds the frontier from the site's sitemaps; entries whose
+	// <lastmod> is before SitemapSince are skipped when it is set.
+	Sitemaps     bool
+	SitemapSince time.Time
+	// SaveErrors keeps the bodies of non-2xx responses under OutDir/errors.
+	SaveErrors bool
+	// WARC archives every response as WARC 1.1 under OutDir/warc, rotating
+	// files past WARCMaxSize bytes; WARCOnly skips the mirrored HTML tree.
+	WARC        bool
+	WARCOnly    bool
+	WARCMaxSize int64
+	// Canonical sets how URLs are normalized for dedupe and the manifest,
+	// urlnorm.Default when nil; Normalizer, when set, replaces it.
+	// IgnoreRelCanonical stops pages from naming their canonical URL with
+	// <link rel="canonical">.
+	Canonical          *urlnorm.Rules
+	Normalizer         urlnorm.Normalizer `json:"-"`
+	IgnoreRelCanonical bool
+	// IgnoreRobotsMeta disregards noindex, nofollow and noarchive in
+	// <meta name="robots">, X-Robots-Tag and rel="nofollow" links.
+	IgnoreRobotsMeta bool
+	// MaxRedirects limits the redirects followed per URL; 0 means
+	// fetch.DefaultMaxRedirects and a negative value follows none.
+	MaxRedirects int
+	// MaxBodySize truncates pages past this many bytes; 0 means no limit.
+	// Non-HTML bodies are not downloaded unless they are archived to WARC.
+	MaxBodySize int64
+	// Include, Exclude and Scope limit which discovered URLs are crawled; see
+	// newScope and compilePatterns for the syntax. Rejected URLs are recorded
+	// in the manifest with the reason.
+	Include []string
+	Exclude []string
+	Scope   []string
+	// MinDelay and MaxRobotsDelay are passed on as fetch.Config; Retry is the
+	// retry policy, fetch.DefaultRetryPolicy when nil.
+	MinDelay       time.Duration
+	MaxRobotsDelay time.Duration
+	Retry          *fetch.RetryPolicy
+	// Incremental re-crawls an output directory: pages saved by an earlier
+	// run are requested conditionally on their ETag and Last-Modified, a 304
+	// keeps the saved copy, and a Report of new, changed, unchanged and
+	// disappeared URLs is written to ReportFile.
+	Incremental bool
+	// CookieFile is a Netscape cookies.txt file loaded before the crawl and
+	// written back, with the cookies the site set, when it ends. Cookies are
+	// added in Set-Cookie syntax ("name=value; Domain=example.com") as if
+	// the start URL had set them; they are not kept in the crawl state.
+	CookieFile string
+	Cookies    []string `json:"-"`
+	// HeaderFile is a JSON file of per-host header profiles, see
+	// fetch.LoadHeaderProfiles; Headers are applied after it. BasicAuth
+	// ("user:password") and BearerToken set Authorization for the start
+	// URL's host only. None of these but the file path is kept in the crawl
+	// state, and credentials are redacted from WARC request records.
+	HeaderFile  string
+	Headers     []fetch.HeaderProfile `json:"-"`
+	BasicAuth   string                `json:"-"`
+	BearerToken string                `json:"-"`
+	// Proxies are proxy URLs (http, https or socks5) to send requests
+	// through, joined by those listed in ProxyFile, one per line. Requests
+	// rotate over them by ProxyRotation, fetch.RotateRoundRobin by default,
+	// and a proxy failing ProxyMaxFailures times in a row is left out for
+	// ProxyCooldown. Proxies may hold credentials, so only the file path is
+	// kept in the crawl state.
+	Proxies          []string `json:"-"`
+	ProxyFile        string
+	ProxyRotation    string
+	ProxyMaxFailures int
+	ProxyCooldown    time.Duration
+	// Transport tunes timeouts, connection pooling, HTTP/2, TLS trust and
+	// name resolution; the zero value keeps the net/http defaults.
+	Transport fetch.TransportConfig
+	// Strategy orders the frontier of each host: StrategyBFS (the default),
+	// StrategyDFS, StrategyBestFirst by Scoring, DefaultScoring when nil, or
+	// StrategyRandom. NewOrder, when set, replaces it.
+	Strategy string
+	Scoring  *Scoring
+	NewOrder func() Order `json:"-"`
+	// OnEvent, when set, is called for every event of the crawl. Calls are
+	// serialized, so it need not be safe for concurrent use; the crawl waits
+	// for each call, so it should return quickly.
+	OnEvent func(Event) `json:"-"`
 }
 
-func Crawl(opts Options) error {
+// Crawler runs a single crawl. It owns its robots.txt cache, per-host
+// throttle and dedupe state, so several Crawlers can run in one process.
+type Crawler struct {
+	opts    Options
+	norm    urlnorm.Normalizer
+	start   *url.URL
+	scope   *scope
+	fetcher *fetch.Fetcher
+	jar     *fetch.Jar
+	order   func() Order
+
+	mu      sync.Mutex // guards subs and started
+	subs    []chan Event
+	started bool
+	// emitMu serializes the delivery of events; mu is not held meanwhile, so
+	// OnEvent may call the Crawler's methods.
+	emitMu sync.Mutex
+}
+
+// NewCrawler returns a Crawler for opts.
+func NewCrawler(opts Options) (*Crawler, error) {
 	start, err := url.Parse(opts.StartURL)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	client := fetch.NewHTTPClient(opts.TimeoutSecs)
-	visited := make(map[string]bool)
-	type qi struct {
-		u     *url.URL
-		depth int
+	if start.User != nil {
+		// credentials in the start URL would end up in the manifest and state
+		if opts.BasicAuth == "" {
+			password, _ := start.User.Password()
+			opts.BasicAuth = start.User.Username() + ":" + password
+		}
+		start.User = nil
+		opts.StartURL = start.String()
 	}
-	queue := []qi{{u: start, depth: 0}}
-	pages := 0
-
-	for len(queue) > 0 {
-		item := queue[0]
-		queue = queue[1:]
-		can := canonicalURL(item.u)
-		if visited[can] {
-			continue
+	sc, err := newScope(opts, start)
+	if err != nil {
+		return nil, err
+	}
+	order, err := opts.orderFunc()
+	if err != nil {
+		return nil, err
+	}
+	retry := fetch.DefaultRetryPolicy
+	if opts.Retry != nil {
+		retry = *opts.Retry
+	}
+	jar := fetch.NewJar()
+	if opts.CookieFile != "" {
+		if err := jar.LoadFile(opts.CookieFile); err != nil {
+			return nil, err
 		}
-		if opts.MaxPages > 0 && pages >= opts.MaxPages {
-			break
+	}
+	for _, c := range opts.Cookies {
+		if err := jar.AddCookie(start, c); err != nil {
+			return nil, err
 		}
-		visited[can] = true
+	}
+	headers, err := opts.headerProfiles(start)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := opts.proxyPool()
+	if err != nil {
+		return nil, err
+	}
+	transport, err := fetch.NewTransport(opts.Transport)
+	if err != nil {
+		return nil, err
+	}
+	if proxies != nil {
+		transport.Proxy = proxies.Proxy
+	}
+	client := fetch.NewHTTPClient(opts.TimeoutSecs)
+	client.Jar, client.Transport = jar, transport
+	f := fetch.NewFetcher(client, fetch.Config{
+		MinDelay:       opts.MinDelay,
+		MaxRobotsDelay: opts.MaxRobotsDelay,
+		Retry:          retry,
+		MaxRedirects:   opts.MaxRedirects,
+		MaxBodySize:    opts.MaxBodySize,
+		Headers:        headers,
+		Proxies:        proxies,
+		// redirects are held to the same scope as links
+		CheckRedirect: func(to *url.URL) error {
+			if reason, ok := sc.allow(to); !ok {
+				return &scopeError{url: to.String(), reason: reason}
+			}
+			return nil
+		},
+	})
+	return &Crawler{opts: opts, norm: opts.normalizer(), start: start, scope: sc, fetcher: f, jar: jar, order: order}, nil
+}
 
-		doc, body, ctype, err := fetch.FetchDocument(client, item.u.String(), opts.UserAgent)
-		if err != nil || !strings.Contains(strings.ToLower(ctype), "text/html") {
-			continue
+// headerProfiles gathers the request headers of the options, in the order
+// they apply.
+func (o Options) headerProfiles(start *url.URL) ([]fetch.HeaderProfile, error) {
+	var profiles []fetch.HeaderProfile
+	if o.HeaderFile != "" {
+		p, err := fetch.LoadHeaderProfiles(o.HeaderFile)
+		if err != nil {
+			return nil, err
 		}
+		profiles = append(profiles, p...)
+	}
+	profiles = append(profiles, o.Headers...)
+	auth := ""
+	switch {
+	case o.BearerToken != "":
+		auth = "Bearer " + o.BearerToken
+	case o.BasicAuth != "":
+		user, password, _ := strings.Cut(o.BasicAuth, ":")
+		auth = fetch.BasicAuth(user, password)
+	}
+	if auth != "" {
+		profiles = append(profiles, fetch.HeaderProfile{Host: start.Hostname(), Header: http.Header{"Authorization": {auth}}})
+	}
+	return profiles, nil
+}
 
-		if err := saveHTML(opts.OutDir, item.u, body); err == nil {
-			pages++
-			color.Green("✓ Saved (%d): %s", pages, item.u.String())
+// proxyPool returns the pool of the options' proxies, nil when there are none.
+func (o Options) proxyPool() (*fetch.ProxyPool, error) {
+	proxies := o.Proxies
+	if o.ProxyFile != "" {
+		list, err := fetch.ReadProxyList(o.ProxyFile)
+		if err != nil {
+			return nil, err
 		}
-		// content-hash dedupe: skip exploring links if we've seen identical content
-		if seenContent(body) {
-			continue
+		proxies = append(slices.Clip(proxies), list...)
+	}
+	if len(proxies) == 0 {
+		return nil, nil
+	}
+	return fetch.NewProxyPool(proxies, fetch.ProxyOptions{
+		Rotation:    o.ProxyRotation,
+		MaxFailures: o.ProxyMaxFailures,
+		Cooldown:    o.ProxyCooldown,
+	})
+}
+
+func (o Options) normalizer() urlnorm.Normalizer {
+	switch {
+	case o.Normalizer != nil:
+		return o.Normalizer
+	case o.Canonical != nil:
+		return *o.Canonical
+	}
+	return urlnorm.Default
+}
+
+// Events returns a channel receiving every event of the crawl; it is closed
+// after Done. It must be called before Run, and the channel must be drained:
+// the crawl blocks while it is full.
+func (c *Crawler) Events(buffer int) <-chan Event {
+	ch := make(chan Event, buffer)
+	c.mu.Lock()
+	c.subs = append(c.subs, ch)
+	c.mu.Unlock()
+	return ch
+}
+
+// Run crawls until the frontier is exhausted, the page budget is reached or
+// ctx is cancelled. Pages are fetched sequentially unless Options.Concurrency
+// is above 1. On cancellation no new URLs are started, fetches in flight are
+// finished and ctx.Err() is returned. A Crawler can only be run once.
+func (c *Crawler) Run(ctx context.Context) error {
+	if c.opts.Concurrency <= 1 {
+		return c.run(ctx, c.sequential)
+	}
+	return c.run(ctx, c.concurrent)
+}
+
+func (c *Crawler) run(ctx context.Context, crawl func(context.Context, *crawlRun, []queueItem) error) error {
+	c.mu.Lock()
+	started := c.started
+	c.started = true
+	c.mu.Unlock()
+	if started {
+		return errors.New("crawl: Crawler already run")
+	}
+
+	r, queue, err := c.newRun(ctx)
+	if err == nil {
+		err = crawl(ctx, r, queue)
+		r.close()
+	}
+	if c.opts.CookieFile != "" {
+		if werr := c.jar.Save(c.opts.CookieFile); werr != nil {
+			c.emit(Error{Err: fmt.Errorf("save cookies: %w", werr)})
 		}
-		if opts.SaveExtract {
-			sig := parse.ExtractSignals(doc, item.u.String())
-			relDir, fileBase := buildRel(item.u)
-			if err := output.SaveExtraction(opts.OutDir, relDir, fileBase, opts.ExtractSaveFormat, sig); err != nil {
-				color.Yellow("⚠ Warning: Failed to save extraction for %s: %v", item.u.String(), err)
+	}
+	pages := 0
+	var report *Report
+	if r != nil {
+		pages = r.state.pageCount()
+		// an interrupted crawl has not reached every URL, so nothing has disappeared yet
+		if r.manifest.changes != nil && err == nil {
+			report = r.manifest.changes.report()
+			if werr := writeReport(c.opts.OutDir, report); werr != nil {
+				c.emit(Error{Err: fmt.Errorf("write report: %w", werr)})
 			}
 		}
+	}
+	c.emit(Done{Pages: pages, Err: err, Report: report})
 
-		if item.depth >= opts.MaxDepth {
-			continue
+	c.emitMu.Lock()
+	c.mu.Lock()
+	for _, ch := range c.subs {
+		close(ch)
+	}
+	c.subs = nil
+	c.mu.Unlock()
+	c.emitMu.Unlock()
+	return err
+}
+
+func (c *Crawler) emit(ev Event) {
+	c.emitMu.Lock()
+	defer c.emitMu.Unlock()
+	c.mu.Lock()
+	subs := slices.Clone(c.subs)
+	c.mu.Unlock()
+	if c.opts.OnEvent != nil {
+		c.opts.OnEvent(ev)
+	}
+	for _, ch := range subs {
+		ch <- ev
+	}
+}
+
+// crawlRun holds what one crawl shares between its loop and its workers.
+type crawlRun struct {
+	*Crawler
+	state    *crawlState
+	manifest *manifestLog
+	warc     *warc.Writer
+}
+
+// newRun opens the crawl state and manifest and returns the initial frontier.
+func (c *Crawler) newRun(ctx context.Context) (*crawlRun, []queueItem, error) {
+	opts, start := c.opts, c.start
+	r := &crawlRun{Crawler: c}
+	st, frontier, err := openState(opts, start)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.state = st
+	var changes *changeSet
+	if opts.Incremental {
+		var resumed func(*url.URL) bool
+		if opts.Resume {
+			visited := maps.Clone(st.visited)
+			resumed = func(u *url.URL) bool { return visited[st.canon.Normalize(u)] }
 		}
-		for _, link := range extractLinks(doc, item.u) {
-			if link.Scheme != "http" && link.Scheme != "https" {
-				continue
-			}
-			if opts.SameHostOnly && !sameHost(start, link) {
-				continue
-			}
-			queue = append(queue, qi{u: link, depth: item.depth + 1})
+		if changes, err = loadChanges(opts.OutDir, resumed); err != nil {
+			st.close()
+			return nil, nil, err
 		}
 	}
-	color.Cyan("🎉 Crawl complete. Fetched %d page(s)", pages)
-	return nil
+	if r.manifest, err = openManifest(opts.OutDir); err != nil {
+		st.close()
+		return nil, nil, err
+	}
+	r.manifest.changes = changes
+	if opts.WARC || opts.WARCOnly {
+		r.warc, err = warc.NewWriter(filepath.Join(opts.OutDir, warcDir), "scrawler-"+sanitize(start.Hostname()), opts.WARCMaxSize, []warc.Field{
+			{Name: "software", Value: "scrawler"},
+			{Name: "isPartOf", Value: opts.StartURL},
+			{Name: "robots", Value: "obey"},
+			{Name: "http-header-user-agent", Value: opts.UserAgent},
+		})
+		if err != nil {
+			r.close()
+			return nil, nil, err
+		}
+	}
+	for _, it := range r.sitemapSeeds(ctx) {
+		st.push(it)
+		frontier = append(frontier, it)
+	}
+	return r, frontier, nil
 }
 
-func CrawlConcurrent(opts Options) error {
-	start, err := url.Parse(opts.StartURL)
+func (r *crawlRun) close() {
+	r.state.close()
+	if r.manifest != nil {
+		r.manifest.close()
+	}
+	if r.warc != nil {
+		r.warc.Close()
+	}
+}
+
+// Crawl runs a sequential crawl of opts; see Crawler for events and cancellation.
+func Crawl(opts Options) error {
+	return CrawlContext(context.Background(), opts)
+}
+
+// CrawlContext crawls sequentially until the frontier is exhausted, the page
+// budget is reached or ctx is cancelled, in which case it returns ctx.Err()
+// after the current page is finished.
+func CrawlContext(ctx context.Context, opts Options) error {
+	c, err := NewCrawler(opts)
 	if err != nil {
 		return err
 	}
-	client := fetch.NewHTTPClient(opts.TimeoutSecs)
+	return c.run(ctx, c.sequential)
+}
 
-	type job struct {
-		u     *url.URL
-		depth int
+func (c *Crawler) sequential(ctx context.Context, r *crawlRun, seeds []queueItem) error {
+	st := r.state
+	f := r.newFrontier(seeds, 0)
+	defer f.close()
+	// a page that was started is finished even when ctx is cancelled
+	fetchCtx := context.WithoutCancel(ctx)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		item, ok := f.next()
+		if !ok {
+			return nil
+		}
+		if st.claim(item.u) {
+			for _, next := range r.visit(fetchCtx, item) {
+				st.push(next)
+				f.push(next)
+			}
+		}
+		f.done(item)
 	}
-	visited := make(map[string]bool)
-	visitedMu := make(chan struct{}, 1)
-	queue := make(chan job, 2048)
-	done := make(chan struct{})
-	pagesCh := make(chan struct{}, opts.MaxPages+10)
-	activeWorkers := make(chan struct{}, opts.Concurrency)
+}
 
-	queue <- job{u: start, depth: 0}
+// newFrontier returns the frontier of the run, holding seeds, with the order
+// of Options.Strategy and at most perHost fetches in flight per host.
+func (r *crawlRun) newFrontier(seeds []queueItem, perHost int) *frontier {
+	spillDir := r.opts.StateDir
+	if spillDir == "" {
+		spillDir = os.TempDir()
+	}
+	f := newFrontier(r.opts.MaxPages, perHost, r.state.pageCount, spillDir)
+	f.newOrder, f.canon = r.order, r.norm.Normalize
+	for _, it := range seeds {
+		f.push(it)
+	}
+	return f
+}
 
-	worker := func() {
-		activeWorkers <- struct{}{} // Mark worker as active
-		defer func() { <-activeWorkers }() // Mark worker as inactive
+// visit fetches, saves and records a single URL and returns the links found on
+// it that should be crawled next.
+func (r *crawlRun) visit(ctx context.Context, item queueItem) []queueItem {
+	doc, base, ok := r.fetchPage(ctx, item)
+	if !ok || item.depth >= r.opts.MaxDepth {
+		return nil
+	}
+	var next []queueItem
+	for _, link := range extractLinks(doc, base, r.opts.IgnoreRobotsMeta) {
+		if r.admit(link, item.depth+1, base.String()) {
+			next = append(next, queueItem{u: link, depth: item.depth + 1, referrer: base.String()})
+		}
+	}
+	return next
+}
 
-		for j := range queue {
-			if opts.MaxPages > 0 && len(pagesCh) >= opts.MaxPages {
-				continue
+// fetchPage fetches, saves and records a single URL. It returns the parsed
+// document, the URL it was served from after redirects, and whether its links
+// should be explored.
+func (r *crawlRun) fetchPage(ctx context.Context, item queueItem) (*goquery.Document, *url.URL, bool) {
+	opts, st := r.opts, r.state
+	rec := ManifestRecord{
+		URL:          item.u.String(),
+		CanonicalURL: r.norm.Normalize(item.u),
+		Referrer:     item.referrer,
+		Depth:        item.depth,
+		FetchedAt:    time.Now(),
+	}
+	// only HTML is read in full, unless every response is archived
+	var accept func(string) bool
+	if r.warc == nil {
+		accept = isHTML
+	}
+	var since fetch.Validators
+	var prior ManifestRecord
+	if changes := r.manifest.changes; changes != nil {
+		since, prior = changes.validators(opts.OutDir, rec.URL)
+	}
+	page, err := r.fetcher.FetchIf(ctx, item.u.String(), opts.UserAgent, since, accept)
+	rec.DurationMS = time.Since(rec.FetchedAt).Milliseconds()
+	if page != nil && page.NotModified {
+		// the copy saved by the prior run stands in for the body
+		page.ContentType, page.Body = prior.ContentType, nil
+		body, rerr := os.ReadFile(filepath.Join(opts.OutDir, filepath.FromSlash(prior.SavedPath)))
+		if rerr != nil {
+			err = fmt.Errorf("read unchanged copy: %w", rerr)
+		} else {
+			page.Body = body
+		}
+	}
+	final := item.u
+	if page != nil {
+		rec.ContentType, rec.HTTPStatus = page.ContentType, page.StatusCode
+		if !page.Discarded {
+			rec.Bytes, rec.SHA256 = len(page.Body), sha256Hex(page.Body)
+		}
+		rec.Encoding, rec.WireBytes, rec.Truncated = page.ContentEncoding, page.WireBytes, page.Truncated
+		rec.Proxy = page.Proxy
+		rec.ETag, rec.LastModified = page.Header.Get("ETag"), page.Header.Get("Last-Modified")
+		if page.NotModified {
+			// a 304 need not repeat the validators
+			rec.ETag, rec.LastModified = cmp.Or(rec.ETag, prior.ETag), cmp.Or(rec.LastModified, prior.LastModified)
+		}
+		if len(page.Redirects) > 0 {
+			rec.FinalURL, rec.Redirects = page.FinalURL, page.Redirects
+			if u, err := url.Parse(page.FinalURL); err == nil {
+				final = u
 			}
-			can := canonicalURL(j.u)
-			visitedMu <- struct{}{}
-			if visited[can] {
-				<-visitedMu
-				continue
+		}
+	}
+	// noindex and noarchive pages are fetched, but no copy of them is kept
+	var robots parse.Directives
+	if page != nil && !opts.IgnoreRobotsMeta {
+		robots = parse.RobotsDirectives(nil, page.Header, opts.UserAgent)
+		rec.Robots = robots.String()
+	}
+	if page != nil && err != nil && !robots.NoIndex && !robots.NoArchive {
+		r.archive(item, page, &rec)
+	}
+	if err != nil {
+		rec.Status, rec.Error = statusFetchError, err.Error()
+		var rb *fetch.RobotsBlockedError
+		var se *scopeError
+		var he *fetch.HTTPStatusError
+		var pe *fetch.ProxyError
+		if errors.As(err, &pe) {
+			rec.Proxy = pe.Proxy
+		}
+		switch {
+		case errors.As(err, &rb):
+			rec.Status = statusRobotsBlocked
+		case errors.As(err, &se):
+			rec.Status, rec.Reason = statusOutOfScope, se.Error()
+		case errors.As(err, &he):
+			rec.Status = statusHTTPError
+			if opts.SaveErrors {
+				rel, err := saveHTML(filepath.Join(opts.OutDir, errorsDir), final, he.Body)
+				if err != nil {
+					r.emit(Error{URL: item.u.String(), Err: fmt.Errorf("save error page: %w", err)})
+				} else {
+					rec.SavedPath = path.Join(errorsDir, rel)
+				}
 			}
-			visited[can] = true
-			<-visitedMu
+		}
+		st.record(item.u, rec.Status, nil)
+		r.manifest.add(rec)
+		switch rec.Status {
+		case statusRobotsBlocked:
+			r.emit(PageSkipped{URL: rec.URL, Reason: rec.Status})
+		case statusOutOfScope:
+			r.emit(PageSkipped{URL: rec.URL, Reason: rec.Status, Detail: rec.Reason})
+		default:
+			r.emit(Error{URL: rec.URL, Err: err})
+		}
+		return nil, nil, false
+	}
+	// a redirect to a page reached before is a duplicate of it
+	if key := r.norm.Normalize(final); rec.FinalURL != "" && key != rec.CanonicalURL {
+		rec.CanonicalURL = key
+		if !st.alias(final) {
+			rec.Status = statusRedirected
+			st.record(item.u, rec.Status, nil)
+			r.manifest.add(rec)
+			r.emit(PageSkipped{URL: rec.URL, Reason: skipDuplicate, Detail: "redirects to " + rec.FinalURL})
+			return nil, nil, false
+		}
+	}
+	body := page.Body
+	if !isHTML(fetch.MediaType(page.ContentType)) {
+		if !page.Discarded && !robots.NoIndex && !robots.NoArchive {
+			r.archive(item, page, &rec)
+		}
+		rec.Status = statusNotHTML
+		st.record(item.u, rec.Status, nil)
+		r.manifest.add(rec)
+		r.emit(PageSkipped{URL: rec.URL, Reason: rec.Status})
+		return nil, nil, false
+	}
 
-			doc, body, ctype, err := fetch.FetchDocument(client, j.u.String(), opts.UserAgent)
-			if err != nil || !strings.Contains(strings.ToLower(ctype), "text/html") {
-				continue
-			}
+	// the parser expects UTF-8; the saved copy keeps the bytes as served
+	text, cs := parse.ToUTF8(body, page.ContentType)
+	rec.Charset = cs
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(text))
+	if err != nil {
+		rec.Status, rec.Error = statusFetchError, err.Error()
+		st.record(item.u, rec.Status, nil)
+		r.manifest.add(rec)
+		r.emit(Error{URL: rec.URL, Err: err})
+		return nil, nil, false
+	}
 
-			if err := saveHTML(opts.OutDir, j.u, body); err == nil {
-				pagesCh <- struct{}{}
-				color.Green("✓ Saved (%d): %s", len(pagesCh), j.u.String())
-			}
-			// content-hash dedupe: skip enqueueing links if identical content already seen
-			if seenContent(body) {
-				continue
-			}
-			if opts.SaveExtract {
-				sig := parse.ExtractSignals(doc, j.u.String())
-				relDir, fileBase := buildRel(j.u)
-				if err := output.SaveExtraction(opts.OutDir, relDir, fileBase, opts.ExtractSaveFormat, sig); err != nil {
-					color.Yellow("⚠ Warning: Failed to save extraction for %s: %v", j.u.String(), err)
-				}
-			}
+	if !opts.IgnoreRobotsMeta {
+		robots = parse.RobotsDirectives(doc, page.Header, opts.UserAgent)
+		rec.Robots = robots.String()
+	}
+	if robots.NoIndex || robots.NoArchive {
+		rec.Status = statusNoArchive
+		if robots.NoIndex {
+			rec.Status = statusNoIndex
+		}
+		st.record(item.u, rec.Status, nil)
+		r.manifest.add(rec)
+		r.emit(PageSkipped{URL: rec.URL, Reason: rec.Status})
+		return doc, final, !robots.NoFollow
+	}
+	r.archive(item, page, &rec)
 
-			if j.depth >= opts.MaxDepth {
-				continue
-			}
-			for _, link := range extractLinks(doc, j.u) {
-				if link.Scheme != "http" && link.Scheme != "https" {
-					continue
-				}
-				if opts.SameHostOnly && !sameHost(start, link) {
-					continue
-				}
-				if opts.MaxPages > 0 && len(pagesCh) >= opts.MaxPages {
-					break
-				}
-				queue <- job{u: link, depth: j.depth + 1}
+	// a page naming another URL as canonical stands in for it; if that URL
+	// was visited already, the page is treated as a duplicate
+	aliasDup := false
+	if !opts.IgnoreRelCanonical {
+		if c := relCanonical(doc, final); c != nil {
+			if key := r.norm.Normalize(c); key != rec.CanonicalURL {
+				rec.CanonicalURL = key
+				aliasDup = !st.alias(c)
 			}
 		}
-		done <- struct{}{}
 	}
 
-	workers := opts.Concurrency
-	if workers < 1 {
-		workers = 1
+	rec.Status = statusSaved
+	if page.NotModified {
+		rec.Status, rec.SavedPath = statusUnchanged, prior.SavedPath
+	} else if opts.WARCOnly {
+		if rec.WARCFile == "" {
+			rec.Status = statusSaveError
+		}
+	} else if rel, err := saveHTML(opts.OutDir, final, body); err != nil {
+		rec.Status, rec.Error = statusSaveError, err.Error()
+	} else {
+		rec.SavedPath = rel
 	}
-	if workers > runtime.NumCPU()*4 {
-		workers = runtime.NumCPU() * 4
+	pages, dup := st.record(item.u, rec.Status, body)
+	// content-hash dedupe: skip exploring links if we've seen identical content
+	if dup || aliasDup {
+		r.manifest.add(rec)
+		r.emitSaved(rec, pages)
+		r.emit(PageSkipped{URL: rec.URL, Reason: skipDuplicate})
+		return nil, nil, false
 	}
-	for i := 0; i < workers; i++ {
-		go worker()
+	if opts.SaveExtract {
+		sig := parse.ExtractSignals(doc, final.String())
+		sig.Robots, sig.Charset = robots, rec.Charset
+		relDir, fileBase := buildRel(final)
+		if err := output.SaveExtraction(opts.OutDir, relDir, fileBase, opts.ExtractSaveFormat, sig); err != nil {
+			r.emit(Error{URL: rec.URL, Err: fmt.Errorf("save extraction: %w", err)})
+		} else {
+			rec.ExtractPath = output.ExtractionPath(relDir, fileBase, opts.ExtractSaveFormat)
+		}
+	}
+	r.manifest.add(rec)
+	r.emitSaved(rec, pages)
+	if robots.NoFollow {
+		r.emit(PageSkipped{URL: rec.URL, Reason: skipNoFollow})
+		return nil, nil, false
 	}
+	return doc, final, true
+}
 
-	// Improved queue closure logic
-	go func() {
-		if opts.MaxPages > 0 {
-			// Close when max pages reached
-			for {
-				if len(pagesCh) >= opts.MaxPages {
-					close(queue)
-					return
-				}
-				time.Sleep(50 * time.Millisecond)
+// emitSaved reports the outcome of a page whose content was fetched.
+func (r *crawlRun) emitSaved(rec ManifestRecord, pages int) {
+	if kept(rec.Status) {
+		r.emit(PageFetched{Record: rec, Pages: pages})
+	} else {
+		r.emit(Error{URL: rec.URL, Err: errors.New(rec.Status + ": " + rec.Error)})
+	}
+}
+
+// archive writes a fetched page to the WARC output, if enabled.
+func (r *crawlRun) archive(item queueItem, page *fetch.Page, rec *ManifestRecord) {
+	// the body of a 304 is the prior run's copy, archived back then
+	if r.warc == nil || page.NotModified {
+		return
+	}
+	meta := []warc.Field{{Name: "fetchTimeMs", Value: strconv.FormatInt(rec.DurationMS, 10)}, {Name: "depth", Value: strconv.Itoa(item.depth)}}
+	if item.referrer != "" {
+		meta = append(meta, warc.Field{Name: "via", Value: item.referrer})
+	}
+	for _, hop := range page.Redirects {
+		meta = append(meta, warc.Field{Name: "redirect", Value: strconv.Itoa(hop.StatusCode) + " " + hop.URL})
+		// the hop's own exchange, so that its URL can be replayed; the body
+		// of a redirect is not kept
+		header := hop.Header.Clone()
+		header.Del("Content-Length")
+		if _, _, err := r.warc.WriteExchange(warc.Exchange{
+			TargetURI:  hop.URL,
+			Date:       page.FetchedAt,
+			Request:    redactRequest(hop.Request),
+			Proto:      hop.Proto,
+			StatusCode: hop.StatusCode,
+			Header:     header,
+		}); err != nil {
+			r.emit(Error{URL: page.URL, Err: fmt.Errorf("write WARC record: %w", err)})
+		}
+	}
+	file, offset, err := r.warc.WriteExchange(warc.Exchange{
+		TargetURI:  page.FinalURL,
+		Date:       page.FetchedAt,
+		Request:    redactRequest(page.Request),
+		Proto:      page.Proto,
+		Status:     page.Status,
+		StatusCode: page.StatusCode,
+		Header:     page.Header,
+		Body:       page.Body,
+		Truncated:  page.Truncated,
+		Metadata:   meta,
+	})
+	if err != nil {
+		r.emit(Error{URL: page.URL, Err: fmt.Errorf("write WARC record: %w", err)})
+		rec.Error = err.Error()
+		return
+	}
+	rec.WARCFile, rec.WARCOffset = path.Join(warcDir, file), offset
+}
+
+// admit reports whether a discovered link may be added to the frontier. A link
+// rejected by the scope rules is recorded once, with the reason.
+func (r *crawlRun) admit(link *url.URL, depth int, referrer string) bool {
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return false
+	}
+	reason, ok := r.scope.allow(link)
+	if ok {
+		return true
+	}
+	if r.state.claim(link) {
+		r.state.record(link, statusOutOfScope, nil)
+		r.manifest.add(ManifestRecord{
+			URL:          link.String(),
+			CanonicalURL: r.norm.Normalize(link),
+			Referrer:     referrer,
+			Depth:        depth,
+			Status:       statusOutOfScope,
+			Reason:       reason,
+		})
+		r.emit(PageSkipped{URL: link.String(), Reason: statusOutOfScope, Detail: reason})
+	}
+	return false
+}
+
+// CrawlConcurrent runs a concurrent crawl of opts; see Crawler for events and cancellation.
+func CrawlConcurrent(opts Options) error {
+	return CrawlConcurrentContext(context.Background(), opts)
+}
+
+// CrawlConcurrentContext crawls with opts.Concurrency workers. It finishes once
+// the frontier is empty with no fetch in flight or the page budget is used up.
+// When ctx is cancelled no new URLs are started, in-flight fetches are allowed
+// to finish and ctx.Err() is returned.
+func CrawlConcurrentContext(ctx context.Context, opts Options) error {
+	c, err := NewCrawler(opts)
+	if err != nil {
+		return err
+	}
+	return c.run(ctx, c.concurrent)
+}
+
+func (c *Crawler) concurrent(ctx context.Context, r *crawlRun, seeds []queueItem) error {
+	opts, st := c.opts, r.state
+
+	perHost := opts.MaxPerHost
+	switch {
+	case perHost == 0:
+		perHost = DefaultMaxPerHost
+	case perHost < 0:
+		perHost = 0
+	}
+	f := r.newFrontier(seeds, perHost)
+	defer f.close()
+
+	stop := context.AfterFunc(ctx, f.close)
+	defer stop()
+	fetchCtx := context.WithoutCancel(ctx)
+
+	worker := func() {
+		for {
+			j, ok := f.next()
+			if !ok {
+				return
 			}
-		} else {
-			// Close after timeout OR when all workers are idle and queue is empty
-			timeout := time.After(2 * time.Second)
-			for {
-				select {
-				case <-timeout:
-					close(queue)
-					return
-				default:
-					// Check if all workers are idle and queue is empty
-					if len(queue) == 0 && len(activeWorkers) == 0 {
-						// Give a small grace period for any pending enqueues
-						time.Sleep(100 * time.Millisecond)
-						if len(queue) == 0 {
-							close(queue)
-							return
-						}
-					}
-					time.Sleep(50 * time.Millisecond)
+			if st.claim(j.u) {
+				for _, next := range r.visit(fetchCtx, j) {
+					st.push(next)
+					f.push(next)
 				}
 			}
+			f.done(j)
 		}
-	}()
+	}
 
+	// workers mostly wait on the network, so their number is not tied to
+	// the CPUs; the per-host limit keeps them from piling onto one site
+	workers := max(opts.Concurrency, 1)
+	var wg sync.WaitGroup
 	for i := 0; i < workers; i++ {
-		<-done
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker()
+		}()
 	}
-	color.Cyan("🎉 Crawl complete. Fetched %d page(s)", len(pagesCh))
-	return nil
+	wg.Wait()
+	return ctx.Err()
+}
+
+// sitemapSeeds returns the sitemap URLs to add to the frontier of a fresh crawl.
+func (r *crawlRun) sitemapSeeds(ctx context.Context) []queueItem {
+	opts := r.opts
+	if !opts.Sitemaps || opts.Resume {
+		return nil
+	}
+	entries, err := sitemap.Discover(ctx, r.fetcher, opts.StartURL, opts.UserAgent, opts.SitemapSince)
+	if err != nil {
+		r.emit(Error{Err: fmt.Errorf("sitemap discovery: %w", err)})
+		return nil
+	}
+	var seeds []queueItem
+	for _, e := range entries {
+		u, err := url.Parse(e.Loc)
+		if err != nil || !r.admit(u, 0, "") {
+			continue
+		}
+		seeds = append(seeds, queueItem{u: u, depth: 0, priority: e.Priority})
+	}
+	r.emit(SitemapsSeeded{URLs: len(seeds)})
+	return seeds
 }
 
 // helpers (temporary; move to util as needed)
-func sameHost(a, b *url.URL) bool { return strings.EqualFold(a.Hostname(), b.Hostname()) }
-func canonicalURL(u *url.URL) string {
-	clone := *u
-	clone.Fragment = ""
-	if (clone.Scheme == "http" && clone.Port() == "80") || (clone.Scheme == "https" && clone.Port() == "443") {
-		clone.Host = clone.Hostname()
+
+// redactRequest returns a copy of req without credentials, for archiving.
+func redactRequest(req *http.Request) *http.Request {
+	if req == nil {
+		return nil
 	}
-	if clone.Path == "" {
-		clone.Path = "/"
+	req = req.Clone(context.Background())
+	req.Header = fetch.RedactHeader(req.Header)
+	return req
+}
+
+// isHTML reports whether a media type is crawled for links.
+func isHTML(mediaType string) bool {
+	return mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
+
+// relCanonical returns the URL a page declares with <link rel="canonical">, or nil.
+func relCanonical(doc *goquery.Document, base *url.URL) *url.URL {
+	href, ok := doc.Find(`link[rel~="canonical"]`).First().Attr("href")
+	if !ok || strings.TrimSpace(href) == "" {
+		return nil
+	}
+	u, err := base.Parse(strings.TrimSpace(href))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil
 	}
-	return clone.String()
+	u.Fragment = ""
+	return u
 }
-func extractLinks(doc *goquery.Document, base *url.URL) []*url.URL {
+
+// extractLinks returns the links of a page to follow, leaving out
+// rel="nofollow" links unless withNoFollow is set.
+func extractLinks(doc *goquery.Document, base *url.URL, withNoFollow bool) []*url.URL {
 	var out []*url.URL
-	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
-		href, ok := s.Attr("href")
-		if !ok || href == "" {
-			return
-		}
-		u, err := base.Parse(href)
-		if err != nil {
-			return
+	for _, l := range parse.Links(doc, base) {
+		if l.NoFollow && !withNoFollow {
+			continue
 		}
-		u.Fragment = ""
-		out = append(out, u)
-	})
+		out = append(out, l.URL)
+	}
 	return out
 }
-func saveHTML(outDir string, u *url.URL, data []byte) error {
+
+// saveHTML writes a page into the mirrored tree and returns its slash-separated
+// path relative to outDir.
+func saveHTML(outDir string, u *url.URL, data []byte) (string, error) {
 	host := sanitize(u.Hostname())
 	p := u.EscapedPath()
 	if p == "" {
@@ -271,9 +936,12 @@ func saveHTML(outDir string, u *url.URL, data []byte) error {
 	}
 	rel := filepath.Join(host, filepath.FromSlash(p))
 	if err := os.MkdirAll(filepath.Join(outDir, rel), 0o755); err != nil {
-		return err
+		return "", err
+	}
+	if err := os.WriteFile(filepath.Join(outDir, rel, name), data, 0o644); err != nil {
+		return "", err
 	}
-	return os.WriteFile(filepath.Join(outDir, rel, name), data, 0o644)
+	return filepath.ToSlash(filepath.Join(rel, name)), nil
 }
 func sanitize(s string) string {
 	s = strings.ReplaceAll(s, "..", "")
@@ -305,19 +973,18 @@ func buildRel(u *url.URL) (string, string) {
 	return filepath.Join(host, filepath.FromSlash(p)), base
 }
 
-// content hash dedupe
-var seenHashes = make(map[uint64]struct{})
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
 
-func seenContent(b []byte) bool {
+// contentHash is a 64-bit FNV-1a hash used for content dedupe.
+func contentHash(b []byte) uint64 {
 	var h uint64 = 1469598103934665603
 	const prime64 = 1099511628211
 	for i := 0; i < len(b); i++ {
 		h ^= uint64(b[i])
 		h *= prime64
 	}
-	if _, ok := seenHashes[h]; ok {
-		return true
-	}
-	seenHashes[h] = struct{}{}
-	return false
+	return h
 }
@@ -0,0 +1,636 @@
+package crawl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"scrawler/scraper/fetch"
+	"scrawler/scraper/parse"
+)
+
+func newTestSite(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><a href="/a">a</a> <a href="/missing">missing</a></body></html>`))
+	})
+	mux.HandleFunc("/a", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body><p>page a</p></body></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCrawlWritesManifest(t *testing.T) {
+	srv := newTestSite(t)
+	out := t.TempDir()
+	err := Crawl(Options{StartURL: srv.URL + "/", MaxDepth: 1, OutDir: out, SameHostOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byURL := make(map[string]ManifestRecord)
+	for _, rec := range recs {
+		byURL[rec.URL] = rec
+	}
+	if len(byURL) != 3 {
+		t.Fatalf("manifest has %d URLs, want 3: %+v", len(byURL), recs)
+	}
+	home := byURL[srv.URL+"/"]
+	if home.Status != statusSaved || home.SavedPath == "" || home.SHA256 == "" || home.Depth != 0 {
+		t.Errorf("home record = %+v", home)
+	}
+	a := byURL[srv.URL+"/a"]
+	if a.Status != statusSaved || a.Referrer != srv.URL+"/" || a.Depth != 1 {
+		t.Errorf("/a record = %+v", a)
+	}
+	missing := byURL[srv.URL+"/missing"]
+	if missing.Status != statusHTTPError || missing.HTTPStatus != http.StatusNotFound || missing.SavedPath != "" {
+		t.Errorf("/missing record = %+v", missing)
+	}
+}
+
+func TestCrawlersRunIndependently(t *testing.T) {
+	type result struct {
+		fetched, errs int
+		last          Event
+	}
+	run := func(concurrency int, res *result) func() error {
+		srv := newTestSite(t)
+		c, err := NewCrawler(Options{StartURL: srv.URL + "/", MaxDepth: 1, OutDir: t.TempDir(), SameHostOnly: true, Concurrency: concurrency})
+		if err != nil {
+			t.Fatal(err)
+		}
+		events := c.Events(0)
+		return func() error {
+			errc := make(chan error, 1)
+			go func() { errc <- c.Run(context.Background()) }()
+			for ev := range events {
+				switch ev.(type) {
+				case PageFetched:
+					res.fetched++
+				case Error:
+					res.errs++
+				}
+				res.last = ev
+			}
+			return <-errc
+		}
+	}
+
+	var seq, conc result
+	runs := []func() error{run(1, &seq), run(3, &conc)}
+	errc := make(chan error, len(runs))
+	for _, r := range runs {
+		go func() { errc <- r() }()
+	}
+	for range runs {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, res := range map[string]result{"sequential": seq, "concurrent": conc} {
+		if res.fetched != 2 || res.errs != 1 {
+			t.Errorf("%s: %d fetched, %d errors; want 2 and 1", name, res.fetched, res.errs)
+		}
+		if done, ok := res.last.(Done); !ok || done.Pages != 2 || done.Err != nil {
+			t.Errorf("%s: last event = %#v, want Done with 2 pages", name, res.last)
+		}
+	}
+}
+
+func TestCrawlerOnEventMayCallTheCrawler(t *testing.T) {
+	srv := newTestSite(t)
+	var c *Crawler
+	var again error
+	opts := Options{StartURL: srv.URL + "/", MaxDepth: 1, OutDir: t.TempDir(), SameHostOnly: true, Concurrency: 2,
+		OnEvent: func(ev Event) {
+			if _, ok := ev.(PageFetched); ok && again == nil {
+				again = c.Run(context.Background())
+			}
+		}}
+	c, err := NewCrawler(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- c.Run(context.Background()) }()
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("crawl deadlocked on a callback calling the Crawler")
+	}
+	if again == nil {
+		t.Error("a second Run from OnEvent was accepted")
+	}
+}
+
+func TestCrawlDedupesNormalizedAndCanonicalURLs(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<html><body><a href="/a?utm_source=news">a</a> <a href="/a">a</a> <a href="/b">b</a></body></html>`))
+		case "/a":
+			w.Write([]byte(`<html><body>a</body></html>`))
+		case "/b":
+			w.Write([]byte(`<html><head><link rel="canonical" href="/a"></head><body>b <a href="/c">c</a></body></html>`))
+		case "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			t.Errorf("unexpected fetch of %s", r.URL)
+			http.NotFound(w, r)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	out := t.TempDir()
+	if err := Crawl(Options{StartURL: srv.URL + "/", MaxDepth: 2, OutDir: out, SameHostOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	for _, rec := range recs {
+		urls = append(urls, rec.URL)
+		if rec.URL == srv.URL+"/b" && rec.CanonicalURL != srv.URL+"/a" {
+			t.Errorf("/b canonical_url = %s, want %s/a", rec.CanonicalURL, srv.URL)
+		}
+	}
+	want := []string{srv.URL + "/", srv.URL + "/a?utm_source=news", srv.URL + "/b"}
+	if strings.Join(urls, " ") != strings.Join(want, " ") {
+		t.Errorf("fetched %v, want %v", urls, want)
+	}
+}
+
+func TestCrawlHonoursRobotsDirectives(t *testing.T) {
+	fetched := make(map[string]bool)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fetched[r.URL.Path] = true
+		w.Header().Set("Content-Type", "text/html")
+		switch r.URL.Path {
+		case "/":
+			w.Write([]byte(`<html><body><a href="/hidden">h</a> <a rel="nofollow" href="/skip">s</a> <a href="/leaf">l</a></body></html>`))
+		case "/hidden":
+			w.Write([]byte(`<html><head><meta name="robots" content="noindex"></head><body><a href="/deep">d</a></body></html>`))
+		case "/leaf":
+			w.Header().Set("X-Robots-Tag", "nofollow")
+			w.Write([]byte(`<html><body><a href="/never">n</a></body></html>`))
+		case "/robots.txt":
+			http.NotFound(w, r)
+		default:
+			w.Write([]byte(`<html><body>ok</body></html>`))
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	out := t.TempDir()
+	if err := Crawl(Options{StartURL: srv.URL + "/", MaxDepth: 3, OutDir: out, SameHostOnly: true}); err != nil {
+		t.Fatal(err)
+	}
+	if fetched["/skip"] || fetched["/never"] || !fetched["/deep"] {
+		t.Errorf("fetched %v; want /deep but not /skip or /never", fetched)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		switch rec.URL {
+		case srv.URL + "/hidden":
+			if rec.Status != statusNoIndex || rec.SavedPath != "" || rec.Robots != "noindex" {
+				t.Errorf("/hidden record = %+v", rec)
+			}
+		case srv.URL + "/leaf":
+			if rec.Status != statusSaved || rec.Robots != "nofollow" {
+				t.Errorf("/leaf record = %+v", rec)
+			}
+		}
+	}
+}
+
+func TestCrawlFollowsRedirectsWithinScope(t *testing.T) {
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("off-host redirect target %s was fetched", r.URL)
+	}))
+	defer other.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><a href="/old">old</a> <a href="/again">again</a> <a href="/away">away</a></body></html>`))
+		case "/docs/new":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><a href="page">relative</a></body></html>`))
+		case "/docs/page":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body>page</body></html>`))
+		case "/old", "/again":
+			http.Redirect(w, r, "/docs/new", http.StatusMovedPermanently)
+		case "/away":
+			// a different host name for the same loopback interface
+			http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/", http.StatusFound)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	out := t.TempDir()
+	if err := Crawl(Options{StartURL: srv.URL + "/", MaxDepth: 2, OutDir: out, SameHostOnly: true, WARC: true}); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byURL := make(map[string]ManifestRecord)
+	for _, rec := range recs {
+		byURL[rec.URL] = rec
+	}
+	old := byURL[srv.URL+"/old"]
+	if old.Status != statusSaved || old.FinalURL != srv.URL+"/docs/new" || len(old.Redirects) != 1 || old.SavedPath != filepath.ToSlash(filepath.Join(sanitize("127.0.0.1"), "docs", "new.html")) {
+		t.Errorf("/old record = %+v", old)
+	}
+	if again := byURL[srv.URL+"/again"]; again.Status != statusRedirected {
+		t.Errorf("/again record = %+v", again)
+	}
+	if page := byURL[srv.URL+"/docs/page"]; page.Status != statusSaved || page.Referrer != srv.URL+"/docs/new" {
+		t.Errorf("/docs/page record = %+v", page)
+	}
+	if away := byURL[srv.URL+"/away"]; away.Status != statusOutOfScope || !strings.Contains(away.Reason, "not in scope") {
+		t.Errorf("/away record = %+v", away)
+	}
+	// the hop is archived under its own URL, so the link to /old can be replayed
+	data := readWARC(t, filepath.Join(out, old.WARCFile))
+	hop := "WARC-Target-URI: " + srv.URL + "/old\r\nWARC-Warcinfo-ID: "
+	if i := bytes.Index(data, []byte(hop)); i < 0 || !bytes.Contains(data[i:], []byte("HTTP/1.1 301 Moved Permanently\r\n")) ||
+		!bytes.Contains(data[i:], []byte("Location: /docs/new\r\n")) {
+		t.Errorf("no response record for the redirect hop:\n%s", data)
+	}
+}
+
+// readWARC returns the records of a WARC file, decompressed.
+func readWARC(t *testing.T, path string) []byte {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestCrawlDecodesLegacyCharsets(t *testing.T) {
+	// "Новости" in windows-1251, announced only in the Content-Type header
+	page := []byte("<html><head><title>\xcd\xee\xe2\xee\xf1\xf2\xe8</title></head><body><p>ok</p></body></html>")
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+		w.Write(page)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	out := t.TempDir()
+	err := Crawl(Options{StartURL: srv.URL + "/", OutDir: out, SameHostOnly: true, SaveExtract: true, ExtractSaveFormat: "json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Charset != "windows-1251" || recs[0].ExtractPath == "" {
+		t.Fatalf("manifest = %+v", recs)
+	}
+	data, err := os.ReadFile(filepath.Join(out, recs[0].ExtractPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sig parse.Signals
+	if err := json.Unmarshal(data, &sig); err != nil {
+		t.Fatal(err)
+	}
+	if sig.Title != "Новости" || sig.Charset != "windows-1251" {
+		t.Errorf("extraction = %+v", sig)
+	}
+	saved, err := os.ReadFile(filepath.Join(out, recs[0].SavedPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(saved, page) {
+		t.Errorf("saved copy was re-encoded: %q", saved)
+	}
+}
+
+func TestCrawlIncrementalReportsChanges(t *testing.T) {
+	pages := map[string]string{
+		"/":       `<html><body><a href="/same">s</a> <a href="/edited">e</a> <a href="/gone">g</a></body></html>`,
+		"/same":   `<html><body>same</body></html>`,
+		"/edited": `<html><body>first</body></html>`,
+		"/gone":   `<html><body>gone soon</body></html>`,
+	}
+	var conditional []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, ok := pages[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		etag := `"` + sha256Hex([]byte(body))[:8] + `"`
+		w.Header().Set("ETag", etag)
+		if inm := r.Header.Get("If-None-Match"); inm != "" {
+			conditional = append(conditional, r.URL.Path)
+			if inm == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(body))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	out := t.TempDir()
+	opts := Options{StartURL: srv.URL + "/", MaxDepth: 1, OutDir: out, SameHostOnly: true, Incremental: true}
+	if err := Crawl(opts); err != nil {
+		t.Fatal(err)
+	}
+	pages["/"] = `<html><body><a href="/same">s</a> <a href="/edited">e</a> <a href="/added">a</a></body></html>`
+	pages["/edited"] = `<html><body>second</body></html>`
+	pages["/added"] = `<html><body>new</body></html>`
+	delete(pages, "/gone")
+
+	var done Done
+	opts.OnEvent = func(ev Event) {
+		if d, ok := ev.(Done); ok {
+			done = d
+		}
+	}
+	if err := Crawl(opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(conditional) != 3 {
+		t.Errorf("conditional requests for %v, want /, /same and /edited", conditional)
+	}
+
+	data, err := os.ReadFile(filepath.Join(out, ReportFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rep Report
+	if err := json.Unmarshal(data, &rep); err != nil {
+		t.Fatal(err)
+	}
+	want := Report{
+		New:         []string{srv.URL + "/added"},
+		Changed:     []string{srv.URL + "/", srv.URL + "/edited"},
+		Unchanged:   []string{srv.URL + "/same"},
+		Disappeared: []string{srv.URL + "/gone"},
+	}
+	if !reflect.DeepEqual(rep, want) {
+		t.Errorf("report = %+v, want %+v", rep, want)
+	}
+	if done.Report == nil || !reflect.DeepEqual(*done.Report, want) {
+		t.Errorf("Done report = %+v", done.Report)
+	}
+
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	same := recs[len(recs)-1]
+	for _, rec := range recs {
+		if rec.URL == srv.URL+"/same" {
+			same = rec
+		}
+	}
+	if same.Status != statusUnchanged || same.SavedPath == "" || same.ETag == "" || same.SHA256 != sha256Hex([]byte(pages["/same"])) {
+		t.Errorf("/same record = %+v", same)
+	}
+	if saved, err := os.ReadFile(filepath.Join(out, same.SavedPath)); err != nil || string(saved) != pages["/same"] {
+		t.Errorf("saved copy of /same = %q, %v", saved, err)
+	}
+}
+
+func TestCrawlUsesAndSavesCookies(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie("auth"); err != nil {
+			http.Error(w, "login required", http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			http.SetCookie(w, &http.Cookie{Name: "visited", Value: "yes", Path: "/", MaxAge: 3600})
+			w.Write([]byte(`<html><body><a href="/a">a</a></body></html>`))
+			return
+		}
+		if _, err := r.Cookie("visited"); err != nil {
+			http.Error(w, "no session", http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`<html><body>a</body></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	out := t.TempDir()
+	jarFile := filepath.Join(out, "cookies.txt")
+	host := strings.TrimPrefix(srv.URL, "http://")
+	host = host[:strings.LastIndex(host, ":")]
+	if err := os.WriteFile(jarFile, []byte(host+"\tFALSE\t/\tFALSE\t0\tauth\tt0ken\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	err := Crawl(Options{StartURL: srv.URL + "/", MaxDepth: 1, OutDir: out, SameHostOnly: true, CookieFile: jarFile, Cookies: []string{"lang=en"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rec := range recs {
+		if rec.Status != statusSaved {
+			t.Errorf("%s: %s %d", rec.URL, rec.Status, rec.HTTPStatus)
+		}
+	}
+	saved, err := os.ReadFile(jarFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"\tauth\tt0ken", "\tvisited\tyes", "\tlang\ten"} {
+		if !strings.Contains(string(saved), name) {
+			t.Errorf("saved cookies lack %q:\n%s", name, saved)
+		}
+	}
+}
+
+func TestCrawlSendsAuthAndRedactsIt(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Basic dXNlcjpwYXNz" || r.Header.Get("Accept-Language") != "de" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte(`<html><body>secret page</body></html>`))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	out := t.TempDir()
+	start := strings.Replace(srv.URL, "http://", "http://user:pass@", 1) + "/"
+	opts := Options{
+		StartURL: start, OutDir: out, SameHostOnly: true, WARC: true,
+		Headers: []fetch.HeaderProfile{{Header: http.Header{"Accept-Language": {"de"}}}},
+	}
+	if err := Crawl(opts); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Status != statusSaved || recs[0].URL != srv.URL+"/" {
+		t.Fatalf("manifest = %+v", recs)
+	}
+	data := readWARC(t, filepath.Join(out, recs[0].WARCFile))
+	if bytes.Contains(data, []byte("dXNlcjpwYXNz")) || bytes.Contains(data, []byte("user:pass")) || !bytes.Contains(data, []byte("Authorization: "+fetch.Redacted)) {
+		t.Errorf("WARC request record not redacted:\n%s", data)
+	}
+}
+
+func TestCrawlRecordsProxyPerFetch(t *testing.T) {
+	// a forward proxy that answers for site.test itself
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "site.test" || r.Header.Get("Proxy-Authorization") == "" {
+			http.Error(w, "bad proxy request", http.StatusBadGateway)
+			return
+		}
+		switch r.URL.Path {
+		case "/":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body><a href="/a">a</a></body></html>`))
+		case "/a":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte(`<html><body>a</body></html>`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer proxy.Close()
+
+	out := t.TempDir()
+	list := filepath.Join(t.TempDir(), "proxies.txt")
+	withCreds := strings.Replace(proxy.URL, "http://", "http://user:s3cret@", 1)
+	os.WriteFile(list, []byte("# egress\n"+withCreds+"\n"), 0o600)
+	opts := Options{StartURL: "http://site.test/", OutDir: out, MaxDepth: 1, SameHostOnly: true, ProxyFile: list}
+	if err := Crawl(opts); err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Replace(proxy.URL, "http://", "http://user:xxxxx@", 1)
+	if len(recs) != 2 {
+		t.Fatalf("manifest = %+v", recs)
+	}
+	for _, rec := range recs {
+		if rec.Status != statusSaved || rec.Proxy != want {
+			t.Errorf("%s: status %s, proxy %q; want saved through %q", rec.URL, rec.Status, rec.Proxy, want)
+		}
+	}
+}
+
+func TestCrawlConcurrentLimitsFetchesPerHost(t *testing.T) {
+	var mu sync.Mutex
+	inFlight, peak := 0, 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		peak = max(peak, inFlight)
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path == "/" {
+			var links strings.Builder
+			for i := range 12 {
+				fmt.Fprintf(&links, `<a href="/p%d">p</a>`, i)
+			}
+			w.Write([]byte("<html><body>" + links.String() + "</body></html>"))
+			return
+		}
+		w.Write([]byte("<html><body>leaf</body></html>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	// far more workers than the host may take, and than the old CPU-based cap
+	err := CrawlConcurrent(Options{StartURL: srv.URL + "/", MaxDepth: 1, OutDir: t.TempDir(), SameHostOnly: true,
+		Concurrency: 256, MaxPerHost: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peak != 2 {
+		t.Errorf("peak requests in flight = %d, want 2", peak)
+	}
+}
@@ -0,0 +1,54 @@
+package crawl
+
+// Event is something that happened during a crawl: PageFetched, PageSkipped,
+// SitemapsSeeded, Error or Done.
+type Event interface {
+	event()
+}
+
+// PageFetched reports a page that was fetched and saved, or found unchanged
+// by an incremental crawl. Pages is the number of pages saved so far, Record
+// the page's manifest entry.
+type PageFetched struct {
+	Record ManifestRecord
+	Pages  int
+}
+
+// PageSkipped reports a URL that was not saved or whose links are not followed.
+type PageSkipped struct {
+	URL    string
+	Reason string // a manifest status such as "robots-blocked", or "duplicate" or "nofollow"
+	Detail string // for "out-of-scope", the rule that rejected the URL
+}
+
+// SitemapsSeeded reports how many sitemap URLs were added to the frontier.
+type SitemapsSeeded struct {
+	URLs int
+}
+
+// Error reports a URL that failed, or a non-fatal problem such as an output
+// that could not be written. URL is empty for crawl-wide problems.
+type Error struct {
+	URL string
+	Err error
+}
+
+// Done is the last event of a crawl. Err is nil when the crawl ran to
+// completion and the context's error when it was interrupted.
+type Done struct {
+	Pages  int
+	Err    error
+	Report *Report // set when an incremental crawl completes
+}
+
+func (PageFetched) event()    {}
+func (PageSkipped) event()    {}
+func (SitemapsSeeded) event() {}
+func (Error) event()          {}
+func (Done) event()           {}
+
+// PageSkipped reasons that are not manifest statuses.
+const (
+	skipDuplicate = "duplicate" // content or canonical URL seen before
+	skipNoFollow  = "nofollow"  // saved, but its links are not followed
+)
@@ -0,0 +1,279 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// defaultSpillAt is how many queued URLs are kept in memory before the
+// frontier starts spilling to disk.
+const defaultSpillAt = 100000
+
+// frontier is the crawl queue. Each host has its own Order, FIFO unless
+// newOrder makes another, and hosts take turns round-robin, skipping those
+// with perHost items in flight, so one busy host cannot occupy every worker.
+// It counts the items handed out to workers, so the crawl ends exactly when
+// the queue is empty and nothing is in flight, and it holds back new work
+// while in-flight fetches could still use up the page budget. Past spillAt
+// queued items, new items go to a temporary file and are read back in order
+// as memory drains; the Orders only rank the items in memory. A URL is queued
+// once: further links to it while it waits only raise its in-link count.
+type frontier struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	hosts    map[string]*hostQueue
+	ring     []*hostQueue // hosts with queued items, in turn order
+	turn     int          // index into ring of the host to try first
+	queued   int          // items in memory
+	inFlight int
+	perHost  int // items in flight per host, 0 for no limit
+	newOrder func() Order
+	canon    func(*url.URL) string // what queued URLs are told apart by
+	waiting  map[string]*waitingURL
+	closed   bool
+	maxPages int
+	pages    func() int
+
+	spillAt   int
+	spillDir  string
+	spillFile *os.File
+	spillEnc  *json.Encoder
+	spillRead *bufio.Reader
+	spillSrc  *os.File
+	spilled   int // items in the spill file not read back yet
+}
+
+// waitingURL is a queued URL, in memory or spilled.
+type waitingURL struct {
+	u       *url.URL // as pushed to its host's Order; nil while spilled
+	inLinks int
+}
+
+// hostQueue is the part of the frontier for one host.
+type hostQueue struct {
+	order    Order
+	inFlight int
+	inRing   bool
+}
+
+func newFrontier(maxPages, perHost int, pages func() int, spillDir string) *frontier {
+	f := &frontier{
+		hosts:    make(map[string]*hostQueue),
+		perHost:  perHost,
+		newOrder: func() Order { return &fifoOrder{} },
+		canon:    (*url.URL).String,
+		waiting:  make(map[string]*waitingURL),
+		maxPages: maxPages,
+		pages:    pages,
+		spillAt:  defaultSpillAt,
+		spillDir: spillDir,
+	}
+	f.cond = sync.NewCond(&f.mu)
+	return f
+}
+
+// hostKey is what the per-host limit counts by.
+func hostKey(u *url.URL) string {
+	return strings.ToLower(u.Host)
+}
+
+// push adds an item to its host's queue. An item already queued gets another
+// in-link instead, and its Order is told when it is a Relinker.
+func (f *frontier) push(it queueItem) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return
+	}
+	key := f.canon(it.u)
+	if w := f.waiting[key]; w != nil {
+		w.inLinks++
+		if w.u == nil {
+			// spilled; read back with the count
+			return
+		}
+		if q := f.hosts[hostKey(w.u)]; q != nil {
+			if r, ok := q.order.(Relinker); ok {
+				r.Relink(w.u, w.inLinks)
+			}
+		}
+		return
+	}
+	w := &waitingURL{inLinks: 1}
+	f.waiting[key] = w
+	if f.spilled > 0 || (f.spillAt > 0 && f.queued >= f.spillAt) {
+		if f.spillOut(it) {
+			f.cond.Signal()
+			return
+		}
+	}
+	f.enqueue(it, w)
+	f.cond.Signal()
+}
+
+func (f *frontier) enqueue(it queueItem, w *waitingURL) {
+	key := hostKey(it.u)
+	q := f.hosts[key]
+	if q == nil {
+		q = &hostQueue{order: f.newOrder()}
+		f.hosts[key] = q
+	}
+	w.u = it.u
+	q.order.Push(Candidate{URL: it.u, Depth: it.depth, Referrer: it.referrer, InLinks: w.inLinks, Priority: it.priority})
+	if !q.inRing {
+		q.inRing = true
+		f.ring = append(f.ring, q)
+	}
+	f.queued++
+}
+
+// take hands out the next item of the next host in turn that is below the
+// per-host limit.
+func (f *frontier) take() (queueItem, bool) {
+	for i := range f.ring {
+		j := (f.turn + i) % len(f.ring)
+		q := f.ring[j]
+		if f.perHost > 0 && q.inFlight >= f.perHost {
+			continue
+		}
+		c := q.order.Pop()
+		delete(f.waiting, f.canon(c.URL))
+		it := queueItem{u: c.URL, depth: c.Depth, referrer: c.Referrer, priority: c.Priority}
+		q.inFlight++
+		f.queued--
+		f.turn = j + 1
+		if q.order.Len() == 0 {
+			q.inRing = false
+			f.ring = slices.Delete(f.ring, j, j+1)
+			f.turn = j
+		}
+		if len(f.ring) > 0 {
+			f.turn %= len(f.ring)
+		}
+		return it, true
+	}
+	return queueItem{}, false
+}
+
+// next blocks until an item can be handed out and marks it in flight. It
+// returns false once the crawl is over: the frontier was closed, the page
+// budget is used up, or the queue is empty with nothing in flight.
+func (f *frontier) next() (queueItem, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for {
+		if f.closed {
+			return queueItem{}, false
+		}
+		if f.maxPages > 0 && f.pages() >= f.maxPages {
+			f.closeLocked()
+			return queueItem{}, false
+		}
+		if f.spilled > 0 && (f.spillAt <= 0 || f.queued < f.spillAt) {
+			f.spillIn()
+		}
+		if f.maxPages <= 0 || f.pages()+f.inFlight < f.maxPages {
+			if it, ok := f.take(); ok {
+				f.inFlight++
+				return it, true
+			}
+		}
+		if f.queued == 0 && f.spilled == 0 && f.inFlight == 0 {
+			f.closeLocked()
+			return queueItem{}, false
+		}
+		f.cond.Wait()
+	}
+}
+
+// done marks an item handed out by next as finished.
+func (f *frontier) done(it queueItem) {
+	f.mu.Lock()
+	f.inFlight--
+	key := hostKey(it.u)
+	if q := f.hosts[key]; q != nil {
+		q.inFlight--
+		if q.inFlight == 0 && !q.inRing {
+			delete(f.hosts, key)
+		}
+	}
+	f.mu.Unlock()
+	f.cond.Broadcast()
+}
+
+// close stops handing out work; items still in flight may finish.
+func (f *frontier) close() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closeLocked()
+}
+
+func (f *frontier) closeLocked() {
+	f.closed = true
+	if f.spillFile != nil {
+		f.spillFile.Close()
+		f.spillSrc.Close()
+		os.Remove(f.spillFile.Name())
+		f.spillFile, f.spillEnc, f.spillRead, f.spillSrc = nil, nil, nil, nil
+	}
+	f.cond.Broadcast()
+}
+
+// spillOut appends an item to the spill file, creating it on first use. It
+// reports false if the file is unusable, in which case the caller keeps the
+// item in memory.
+func (f *frontier) spillOut(it queueItem) bool {
+	if f.spillFile == nil {
+		if f.spilled > 0 {
+			return false
+		}
+		file, err := os.CreateTemp(f.spillDir, "frontier-*.jsonl")
+		if err != nil {
+			return false
+		}
+		r, err := os.Open(file.Name())
+		if err != nil {
+			file.Close()
+			os.Remove(file.Name())
+			return false
+		}
+		f.spillFile, f.spillEnc, f.spillRead, f.spillSrc = file, json.NewEncoder(file), bufio.NewReader(r), r
+	}
+	if err := f.spillEnc.Encode(stateEvent{Op: "enq", URL: it.u.String(), Depth: it.depth, Ref: it.referrer, Prio: it.priority}); err != nil {
+		return false
+	}
+	f.spilled++
+	return true
+}
+
+// spillIn reads items back from the spill file until spillAt are in memory.
+func (f *frontier) spillIn() {
+	for f.spilled > 0 && (f.spillAt <= 0 || f.queued < f.spillAt) {
+		line, err := f.spillRead.ReadBytes('\n')
+		if err != nil {
+			// the file is gone or truncated; what it held cannot be recovered here
+			f.spilled = 0
+			return
+		}
+		f.spilled--
+		var ev stateEvent
+		if json.Unmarshal(line, &ev) != nil {
+			continue
+		}
+		u, err := url.Parse(ev.URL)
+		if err != nil {
+			continue
+		}
+		w := f.waiting[f.canon(u)]
+		if w == nil {
+			w = &waitingURL{inLinks: 1}
+			f.waiting[f.canon(u)] = w
+		}
+		f.enqueue(queueItem{u: u, depth: ev.Depth, referrer: ev.Ref, priority: ev.Prio}, w)
+	}
+}
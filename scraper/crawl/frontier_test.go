@@ -0,0 +1,155 @@
+package crawl
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestFrontierSpillsInOrder(t *testing.T) {
+	f := newFrontier(0, 0, func() int { return 0 }, t.TempDir())
+	f.spillAt = 3
+	defer f.close()
+
+	for i := 0; i < 10; i++ {
+		f.push(queueItem{u: mustParse(t, fmt.Sprintf("https://example.com/%d", i)), depth: i})
+	}
+	if f.spilled != 7 {
+		t.Fatalf("spilled = %d, want 7", f.spilled)
+	}
+	for i := 0; i < 10; i++ {
+		it, ok := f.next()
+		if !ok {
+			t.Fatalf("next() ended early at %d", i)
+		}
+		if want := fmt.Sprintf("https://example.com/%d", i); it.u.String() != want || it.depth != i {
+			t.Fatalf("item %d = %s depth %d, want %s depth %d", i, it.u, it.depth, want, i)
+		}
+		f.done(it)
+	}
+	if _, ok := f.next(); ok {
+		t.Error("frontier should be finished once empty with nothing in flight")
+	}
+}
+
+func TestFrontierWaitsForInFlightWork(t *testing.T) {
+	f := newFrontier(0, 0, func() int { return 0 }, t.TempDir())
+	f.push(queueItem{u: mustParse(t, "https://example.com/")})
+
+	first, _ := f.next()
+	got := make(chan string, 1)
+	go func() {
+		// blocks: the queue is empty but the first item is still in flight
+		it, ok := f.next()
+		if ok {
+			got <- it.u.String()
+			f.done(it)
+		}
+		close(got)
+	}()
+	f.push(queueItem{u: first.u.ResolveReference(mustParse(t, "/child"))})
+	f.done(first)
+
+	if u := <-got; u != "https://example.com/child" {
+		t.Errorf("second item = %q, want the child pushed by the in-flight worker", u)
+	}
+}
+
+func TestFrontierHoldsBackWorkWithinPageBudget(t *testing.T) {
+	var mu sync.Mutex
+	pages := 0
+	f := newFrontier(2, 0, func() int { mu.Lock(); defer mu.Unlock(); return pages }, t.TempDir())
+	for i := 0; i < 5; i++ {
+		f.push(queueItem{u: mustParse(t, fmt.Sprintf("https://example.com/%d", i))})
+	}
+	first, _ := f.next()
+	second, _ := f.next()
+
+	third := make(chan bool, 1)
+	go func() {
+		_, ok := f.next()
+		third <- ok
+	}()
+	// both in-flight items become pages, exhausting the budget
+	mu.Lock()
+	pages = 2
+	mu.Unlock()
+	f.done(first)
+	f.done(second)
+	if <-third {
+		t.Error("no more work should be handed out once the page budget is used")
+	}
+}
+
+func TestFrontierRotatesHostsWithinPerHostLimit(t *testing.T) {
+	f := newFrontier(0, 1, func() int { return 0 }, t.TempDir())
+	for _, u := range []string{"https://a.test/1", "https://a.test/2", "https://a.test/3", "https://b.test/1", "https://c.test/1", "https://b.test/2"} {
+		f.push(queueItem{u: mustParse(t, u)})
+	}
+	var got []string
+	var out []queueItem
+	for range 3 {
+		it, _ := f.next()
+		got, out = append(got, it.u.String()), append(out, it)
+	}
+	if want := "https://a.test/1 https://b.test/1 https://c.test/1"; strings.Join(got, " ") != want {
+		t.Fatalf("first turn = %v, want %s", got, want)
+	}
+
+	next := make(chan string, 1)
+	go func() {
+		// blocks: a.test and b.test are at their limit, c.test has nothing left
+		it, _ := f.next()
+		next <- it.u.String()
+	}()
+	f.done(out[1])
+	if u := <-next; u != "https://b.test/2" {
+		t.Errorf("after b.test finished got %s, want https://b.test/2", u)
+	}
+	f.done(out[0])
+	if it, _ := f.next(); it.u.String() != "https://a.test/2" {
+		t.Errorf("after a.test finished got %s, want https://a.test/2", it.u)
+	}
+}
+
+// recordingOrder is a FIFO that records what it is given.
+type recordingOrder struct {
+	fifoOrder
+	pushed []Candidate
+}
+
+func (o *recordingOrder) Push(c Candidate) {
+	o.pushed = append(o.pushed, c)
+	o.fifoOrder.Push(c)
+}
+
+func TestFrontierQueuesURLOnceAndCountsLinks(t *testing.T) {
+	order := &recordingOrder{}
+	f := newFrontier(0, 0, func() int { return 0 }, t.TempDir())
+	f.newOrder = func() Order { return order }
+	f.spillAt = 1
+	defer f.close()
+
+	// /a stays in memory, /b is spilled and gets its later links there
+	for _, p := range []string{"/a", "/b", "/b", "/b", "/a"} {
+		f.push(queueItem{u: mustParse(t, "https://example.com"+p)})
+	}
+	for {
+		it, ok := f.next()
+		if !ok {
+			break
+		}
+		f.done(it)
+	}
+	var got []string
+	for _, c := range order.pushed {
+		got = append(got, fmt.Sprintf("%s:%d", c.URL.Path, c.InLinks))
+	}
+	if want := "/a:1 /b:3"; strings.Join(got, " ") != want {
+		t.Errorf("pushed %v, want %s", got, want)
+	}
+	if len(f.waiting) != 0 {
+		t.Errorf("%d URL(s) still waiting after the queue drained", len(f.waiting))
+	}
+}
@@ -0,0 +1,121 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"scrawler/scraper/fetch"
+)
+
+// ManifestFile is the index of every attempted URL, written in the output
+// directory. Records are appended, so resumed crawls extend it.
+const ManifestFile = "manifest.jsonl"
+
+// errorsDir is where --save-errors keeps non-2xx response bodies.
+const errorsDir = "errors"
+
+// warcDir holds the WARC files of a crawl.
+const warcDir = "warc"
+
+// ManifestRecord describes one attempted URL. Paths are relative to the output
+// directory and use forward slashes.
+type ManifestRecord struct {
+	URL          string           `json:"url"`
+	CanonicalURL string           `json:"canonical_url"`
+	FinalURL     string           `json:"final_url,omitempty"` // after redirects
+	Redirects    []fetch.Redirect `json:"redirects,omitempty"`
+	Referrer     string           `json:"referrer,omitempty"`
+	Depth        int              `json:"depth"`
+	Status       string           `json:"status"`
+	HTTPStatus   int              `json:"http_status,omitempty"`
+	ContentType  string           `json:"content_type,omitempty"`
+	Charset      string           `json:"charset,omitempty"` // what the page was decoded from
+	Encoding     string           `json:"content_encoding,omitempty"`
+	Bytes        int              `json:"bytes"`               // decoded
+	WireBytes    int64            `json:"wire_bytes"`          // as transferred, before decoding
+	Truncated    bool             `json:"truncated,omitempty"` // Bytes stops at the body size limit
+	SHA256       string           `json:"sha256,omitempty"`
+	FetchedAt    time.Time        `json:"fetched_at,omitzero"`
+	DurationMS   int64            `json:"duration_ms"`
+	SavedPath    string           `json:"saved_path,omitempty"`
+	ExtractPath  string           `json:"extract_path,omitempty"`
+	WARCFile     string           `json:"warc_file,omitempty"`
+	WARCOffset   int64            `json:"warc_offset,omitempty"`
+	Error        string           `json:"error,omitempty"`
+	Reason       string           `json:"reason,omitempty"` // why an out-of-scope URL was rejected
+	Robots       string           `json:"robots,omitempty"` // noindex/nofollow/noarchive directives found
+	ETag         string           `json:"etag,omitempty"`
+	LastModified string           `json:"last_modified,omitempty"`
+	Proxy        string           `json:"proxy,omitempty"` // fetched through, password redacted
+}
+
+// Kept reports whether the record's page is stored: saved by its run, or
+// found unchanged by an incremental crawl that kept the earlier copy.
+func (rec ManifestRecord) Kept() bool {
+	return kept(rec.Status)
+}
+
+// ReadManifest loads every record of a manifest file.
+func ReadManifest(path string) ([]ManifestRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var recs []ManifestRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec ManifestRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		recs = append(recs, rec)
+	}
+	return recs, scanner.Err()
+}
+
+type manifestLog struct {
+	mu      sync.Mutex
+	f       *os.File
+	enc     *json.Encoder
+	changes *changeSet // set for incremental crawls
+}
+
+func openManifest(outDir string) (*manifestLog, error) {
+	if err := os.MkdirAll(outDir, 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(outDir, ManifestFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &manifestLog{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (l *manifestLog) add(rec ManifestRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.enc == nil {
+		return
+	}
+	_ = l.enc.Encode(rec)
+	if l.changes != nil {
+		l.changes.observe(rec)
+	}
+}
+
+func (l *manifestLog) close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Close()
+	l.f, l.enc = nil, nil
+	return err
+}
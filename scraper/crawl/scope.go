@@ -0,0 +1,236 @@
+package crawl
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"scrawler/scraper/urlnorm"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// scope decides which discovered URLs may enter the frontier. A URL must be
+// on an allowed host, under one of the path prefixes (when there are any),
+// match an include pattern (when there are any) and match no exclude pattern.
+type scope struct {
+	norm     urlnorm.Normalizer
+	startKey string
+	anyHost  bool
+	hosts    []string // exact hostnames; "*.example.com" also allows subdomains
+	domains  []string // registrable domains (eTLD+1)
+	prefixes []string
+	include  []*pattern
+	exclude  []*pattern
+}
+
+// scopeError rejects a redirect to a URL outside the crawl scope.
+type scopeError struct {
+	url    string
+	reason string
+}
+
+func (e *scopeError) Error() string {
+	return "redirect to " + e.url + ": " + e.reason
+}
+
+// pattern is a compiled --include/--exclude entry.
+type pattern struct {
+	src  string
+	re   *regexp.Regexp
+	path bool // matched against the path rather than the whole URL
+}
+
+// newScope compiles opts.Scope, opts.Include and opts.Exclude. Without a host
+// rule in opts.Scope, SameHostOnly selects between "host" and "any".
+//
+// Scope rules:
+//
+//	host          the start URL's host
+//	host:H        host H; host:*.H also allows its subdomains
+//	domain        hosts sharing the start URL's registrable domain (eTLD+1)
+//	domain:D      hosts under D's registrable domain
+//	any           every host
+//	prefix        paths under the start URL's directory
+//	prefix:/P     paths starting with /P
+func newScope(opts Options, start *url.URL) (*scope, error) {
+	norm := opts.normalizer()
+	sc := &scope{norm: norm, startKey: norm.Normalize(start)}
+	hostRule := false
+	for _, rule := range opts.Scope {
+		kind, arg, _ := strings.Cut(strings.TrimSpace(rule), ":")
+		switch kind {
+		case "host":
+			hostRule = true
+			if arg == "" {
+				arg = start.Hostname()
+			}
+			sc.hosts = append(sc.hosts, strings.ToLower(arg))
+		case "domain":
+			hostRule = true
+			if arg == "" {
+				arg = start.Hostname()
+			}
+			d, err := publicsuffix.EffectiveTLDPlusOne(strings.ToLower(arg))
+			if err != nil {
+				return nil, fmt.Errorf("scope %q: %w", rule, err)
+			}
+			sc.domains = append(sc.domains, d)
+		case "any":
+			hostRule = true
+			sc.anyHost = true
+		case "prefix":
+			if arg == "" {
+				arg = start.Path
+				if i := strings.LastIndex(arg, "/"); i >= 0 {
+					arg = arg[:i+1]
+				}
+			}
+			if !strings.HasPrefix(arg, "/") {
+				arg = "/" + arg
+			}
+			sc.prefixes = append(sc.prefixes, arg)
+		default:
+			return nil, fmt.Errorf("unknown scope %q", rule)
+		}
+	}
+	if !hostRule {
+		if opts.SameHostOnly {
+			sc.hosts = []string{strings.ToLower(start.Hostname())}
+		} else {
+			sc.anyHost = true
+		}
+	}
+	var err error
+	if sc.include, err = compilePatterns(opts.Include); err != nil {
+		return nil, err
+	}
+	if sc.exclude, err = compilePatterns(opts.Exclude); err != nil {
+		return nil, err
+	}
+	return sc, nil
+}
+
+// allow reports whether u is in scope and, if not, why.
+func (sc *scope) allow(u *url.URL) (string, bool) {
+	if sc.norm.Normalize(u) == sc.startKey {
+		// the start URL is always crawled, whatever the rules
+		return "", true
+	}
+	if !sc.allowHost(u.Hostname()) {
+		return "host " + u.Hostname() + " not in scope", false
+	}
+	if len(sc.prefixes) > 0 {
+		p := u.Path
+		if p == "" {
+			p = "/"
+		}
+		ok := false
+		for _, prefix := range sc.prefixes {
+			if strings.HasPrefix(p, prefix) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return "path outside " + strings.Join(sc.prefixes, ", "), false
+		}
+	}
+	if len(sc.include) > 0 {
+		ok := false
+		for _, pat := range sc.include {
+			if pat.match(u) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return "no include pattern matched", false
+		}
+	}
+	for _, pat := range sc.exclude {
+		if pat.match(u) {
+			return "excluded by " + pat.src, false
+		}
+	}
+	return "", true
+}
+
+func (sc *scope) allowHost(host string) bool {
+	if sc.anyHost {
+		return true
+	}
+	host = strings.ToLower(host)
+	for _, h := range sc.hosts {
+		if h == host {
+			return true
+		}
+		if parent, ok := strings.CutPrefix(h, "*."); ok && (host == parent || strings.HasSuffix(host, "."+parent)) {
+			return true
+		}
+	}
+	if len(sc.domains) > 0 {
+		if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+			for _, domain := range sc.domains {
+				if d == domain {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// compilePatterns compiles include/exclude entries. Entries starting with
+// "re:" are regular expressions matched against the whole URL. Others are
+// globs, where * matches within a path segment and ** across segments, matched
+// against the path when they start with "/" and against the whole URL otherwise.
+func compilePatterns(srcs []string) ([]*pattern, error) {
+	var out []*pattern
+	for _, src := range srcs {
+		if src == "" {
+			continue
+		}
+		if expr, ok := strings.CutPrefix(src, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("pattern %q: %w", src, err)
+			}
+			out = append(out, &pattern{src: src, re: re})
+			continue
+		}
+		out = append(out, &pattern{src: src, re: regexp.MustCompile(globToRegexp(src)), path: strings.HasPrefix(src, "/")})
+	}
+	return out, nil
+}
+
+func globToRegexp(glob string) string {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		if glob[i] != '*' {
+			b.WriteString(regexp.QuoteMeta(glob[i : i+1]))
+			continue
+		}
+		if i+1 < len(glob) && glob[i+1] == '*' {
+			b.WriteString(".*")
+			i++
+		} else {
+			b.WriteString("[^/]*")
+		}
+	}
+	b.WriteString("$")
+	return b.String()
+}
+
+func (p *pattern) match(u *url.URL) bool {
+	if p.path {
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		return p.re.MatchString(path)
+	}
+	return p.re.MatchString(u.String())
+}
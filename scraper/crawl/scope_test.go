@@ -0,0 +1,74 @@
+package crawl
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestScopeRules(t *testing.T) {
+	tests := []struct {
+		name   string
+		opts   Options
+		url    string
+		want   bool
+		reason string
+	}{
+		{"same host default", Options{SameHostOnly: true}, "https://other.org/", false, "host other.org not in scope"},
+		{"any host without same-host", Options{}, "https://other.org/", true, ""},
+		{"domain allows subdomain", Options{Scope: []string{"domain"}}, "https://blog.example.co.uk/x", true, ""},
+		{"domain rejects sibling", Options{Scope: []string{"domain"}}, "https://example.com/", false, "host example.com not in scope"},
+		{"host allowlist wildcard", Options{Scope: []string{"host", "host:*.cdn.net"}}, "https://img.cdn.net/a.png", true, ""},
+		{"prefix from start", Options{Scope: []string{"prefix"}}, "https://www.example.co.uk/docs/guide/intro", true, ""},
+		{"prefix rejects", Options{Scope: []string{"prefix"}}, "https://www.example.co.uk/blog/", false, "path outside /docs/"},
+		{"include glob", Options{Include: []string{"/docs/**"}}, "https://www.example.co.uk/docs/a/b", true, ""},
+		{"include glob single segment", Options{Include: []string{"/docs/*"}}, "https://www.example.co.uk/docs/a/b", false, "no include pattern matched"},
+		{"exclude regex", Options{Exclude: []string{`re:[?&]sort=`}}, "https://www.example.co.uk/docs/?page=2&sort=asc", false, "excluded by re:[?&]sort="},
+		{"exclude full-url glob", Options{Exclude: []string{"https://www.example.co.uk/**.pdf"}}, "https://www.example.co.uk/docs/a.pdf", false, "excluded by https://www.example.co.uk/**.pdf"},
+		{"start always allowed", Options{Include: []string{"/nothing"}}, "https://www.example.co.uk/docs/index.html", true, ""},
+	}
+	start := mustParse(t, "https://www.example.co.uk/docs/index.html")
+	for _, tc := range tests {
+		sc, err := newScope(tc.opts, start)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		reason, ok := sc.allow(mustParse(t, tc.url))
+		if ok != tc.want || reason != tc.reason {
+			t.Errorf("%s: allow(%s) = %q, %v; want %q, %v", tc.name, tc.url, reason, ok, tc.reason, tc.want)
+		}
+	}
+}
+
+func TestScopeRejectsBadRules(t *testing.T) {
+	start := mustParse(t, "https://example.com/")
+	for _, opts := range []Options{{Scope: []string{"subtree"}}, {Exclude: []string{"re:("}}} {
+		if _, err := newScope(opts, start); err == nil {
+			t.Errorf("newScope(%+v) succeeded, want an error", opts)
+		}
+	}
+}
+
+func TestCrawlRecordsOutOfScopeURLs(t *testing.T) {
+	srv := newTestSite(t)
+	out := t.TempDir()
+	err := Crawl(Options{StartURL: srv.URL + "/", MaxDepth: 1, OutDir: out, SameHostOnly: true, Exclude: []string{"/missing"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	for _, rec := range recs {
+		if rec.URL == srv.URL+"/missing" {
+			found = true
+			if rec.Status != statusOutOfScope || rec.Reason != "excluded by /missing" || rec.Referrer != srv.URL+"/" {
+				t.Errorf("/missing record = %+v", rec)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("no record for the excluded URL: %+v", recs)
+	}
+}
@@ -0,0 +1,323 @@
+package crawl
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"scrawler/scraper/urlnorm"
+)
+
+// A crawl state directory holds the options the crawl was started with and an
+// append-only journal of frontier and visit events. Replaying the journal gives
+// back the visited set, the content hashes and the remaining frontier.
+const (
+	stateOptionsFile = "state.json"
+	stateJournalFile = "journal.jsonl"
+)
+
+// Per-URL statuses recorded in the journal.
+const (
+	statusSaved         = "saved"
+	statusFetchError    = "fetch-error"
+	statusHTTPError     = "http-error"
+	statusRobotsBlocked = "robots-blocked"
+	statusNotHTML       = "not-html"
+	statusSaveError     = "save-error"
+	statusOutOfScope    = "out-of-scope"
+	statusNoIndex       = "noindex"
+	statusNoArchive     = "noarchive"
+	statusRedirected    = "redirected" // redirects to a page crawled before
+	statusUnchanged     = "unchanged"  // 304 to an incremental crawl; the prior copy is kept
+	// statusCanonical marks a URL another page stands in for, by redirecting
+	// to it or naming it rel=canonical; it has no manifest record of its own.
+	statusCanonical = "canonical"
+)
+
+type queueItem struct {
+	u        *url.URL
+	depth    int
+	referrer string
+	priority float64 // sitemap <priority>, 0 when not listed
+}
+
+type stateEvent struct {
+	Op     string  `json:"op"` // "enq" or "visit"
+	URL    string  `json:"url"`
+	Depth  int     `json:"depth,omitempty"`
+	Ref    string  `json:"ref,omitempty"`
+	Status string  `json:"status,omitempty"`
+	Hash   uint64  `json:"hash,omitempty"`
+	Prio   float64 `json:"prio,omitempty"`
+}
+
+// crawlState tracks visited URLs, content hashes and the page count for one
+// crawl, journaling every change when a state directory is configured.
+type crawlState struct {
+	canon   urlnorm.Normalizer
+	mu      sync.Mutex
+	visited map[string]bool
+	hashes  map[uint64]struct{}
+	pages   int
+	journal *os.File
+	enc     *json.Encoder
+}
+
+// LoadStateOptions reads the options a crawl was started with from its state directory.
+func LoadStateOptions(dir string) (Options, error) {
+	var opts Options
+	data, err := os.ReadFile(filepath.Join(dir, stateOptionsFile))
+	if err != nil {
+		return opts, err
+	}
+	if err := json.Unmarshal(data, &opts); err != nil {
+		return opts, fmt.Errorf("parse %s: %w", stateOptionsFile, err)
+	}
+	opts.StateDir = dir
+	return opts, nil
+}
+
+// openState prepares the crawl state and returns the initial frontier. Without
+// a state directory the state lives in memory only; with Resume set the journal
+// is replayed, compacted and reopened for appending.
+func openState(opts Options, start *url.URL) (*crawlState, []queueItem, error) {
+	st := &crawlState{
+		canon:   opts.normalizer(),
+		visited: make(map[string]bool),
+		hashes:  make(map[uint64]struct{}),
+	}
+	if opts.StateDir == "" {
+		return st, []queueItem{{u: start, depth: 0}}, nil
+	}
+	if err := os.MkdirAll(opts.StateDir, 0o755); err != nil {
+		return nil, nil, err
+	}
+
+	var frontier []queueItem
+	if opts.Resume {
+		var err error
+		frontier, err = st.replay(filepath.Join(opts.StateDir, stateJournalFile))
+		if err != nil {
+			return nil, nil, err
+		}
+	} else {
+		frontier = []queueItem{{u: start, depth: 0}}
+	}
+
+	saved := opts
+	saved.StateDir = ""
+	saved.Resume = false
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writeFileAtomic(filepath.Join(opts.StateDir, stateOptionsFile), data); err != nil {
+		return nil, nil, err
+	}
+	if err := st.compact(opts.StateDir, frontier); err != nil {
+		return nil, nil, err
+	}
+	return st, frontier, nil
+}
+
+// replay rebuilds the visited set and hashes from a journal and returns the
+// queued URLs that were never visited, in their original order.
+func (s *crawlState) replay(path string) ([]queueItem, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("no crawl state found in %s", filepath.Dir(path))
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var queued []queueItem
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ev stateEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			// a torn final line from a killed process is expected; skip it
+			continue
+		}
+		u, err := url.Parse(ev.URL)
+		if err != nil {
+			continue
+		}
+		switch ev.Op {
+		case "enq":
+			queued = append(queued, queueItem{u: u, depth: ev.Depth, referrer: ev.Ref, priority: ev.Prio})
+		case "visit":
+			s.visited[s.canon.Normalize(u)] = true
+			if ev.Hash != 0 {
+				s.hashes[ev.Hash] = struct{}{}
+			}
+			if kept(ev.Status) {
+				s.pages++
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var frontier []queueItem
+	for _, it := range queued {
+		if !s.visited[s.canon.Normalize(it.u)] {
+			frontier = append(frontier, it)
+		}
+	}
+	return frontier, nil
+}
+
+// compact rewrites the journal so it only holds the visit history and the
+// current frontier, then keeps it open for appending.
+func (s *crawlState) compact(dir string, frontier []queueItem) error {
+	path := filepath.Join(dir, stateJournalFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	if err := s.copyVisits(path, enc); err != nil {
+		f.Close()
+		return err
+	}
+	for _, it := range frontier {
+		if err := enc.Encode(stateEvent{Op: "enq", URL: it.u.String(), Depth: it.depth, Ref: it.referrer, Prio: it.priority}); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	s.journal, err = os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.enc = json.NewEncoder(s.journal)
+	return nil
+}
+
+// copyVisits copies the visit events of an existing journal, if any.
+func (s *crawlState) copyVisits(path string, enc *json.Encoder) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var ev stateEvent
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil || ev.Op != "visit" {
+			continue
+		}
+		if err := enc.Encode(ev); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// claim marks a URL as visited and reports whether it had not been visited yet.
+func (s *crawlState) claim(u *url.URL) bool {
+	can := s.canon.Normalize(u)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.visited[can] {
+		return false
+	}
+	s.visited[can] = true
+	return true
+}
+
+// alias marks u, the redirect target or rel=canonical URL of a fetched page, as visited
+// and reports whether it had not been visited yet.
+func (s *crawlState) alias(u *url.URL) bool {
+	if !s.claim(u) {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(stateEvent{Op: "visit", URL: u.String(), Status: statusCanonical})
+	return true
+}
+
+// push journals a URL added to the frontier.
+func (s *crawlState) push(it queueItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.write(stateEvent{Op: "enq", URL: it.u.String(), Depth: it.depth, Ref: it.referrer, Prio: it.priority})
+}
+
+// record journals the outcome of a visited URL and returns the page count and
+// whether body duplicates content already seen in this crawl.
+func (s *crawlState) record(u *url.URL, status string, body []byte) (int, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ev := stateEvent{Op: "visit", URL: u.String(), Status: status}
+	dup := false
+	if body != nil {
+		h := contentHash(body)
+		_, dup = s.hashes[h]
+		s.hashes[h] = struct{}{}
+		ev.Hash = h
+	}
+	if kept(status) {
+		s.pages++
+	}
+	s.write(ev)
+	return s.pages, dup
+}
+
+func (s *crawlState) pageCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.pages
+}
+
+func (s *crawlState) write(ev stateEvent) {
+	if s.enc == nil {
+		return
+	}
+	_ = s.enc.Encode(ev)
+}
+
+func (s *crawlState) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.journal == nil {
+		return nil
+	}
+	err := s.journal.Close()
+	s.journal, s.enc = nil, nil
+	return err
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
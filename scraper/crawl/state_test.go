@@ -0,0 +1,66 @@
+package crawl
+
+import (
+	"net/url"
+	"testing"
+)
+
+func mustParse(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestStateResumeRestoresFrontierAndVisited(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{StartURL: "https://example.com/", MaxDepth: 3, StateDir: dir}
+	start := mustParse(t, opts.StartURL)
+
+	st, frontier, err := openState(opts, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frontier) != 1 {
+		t.Fatalf("fresh frontier = %d items, want 1", len(frontier))
+	}
+	st.claim(start)
+	st.record(start, statusSaved, []byte("<html>home</html>"))
+	st.push(queueItem{u: mustParse(t, "https://example.com/a"), depth: 1})
+	st.push(queueItem{u: mustParse(t, "https://example.com/b"), depth: 2})
+	a := mustParse(t, "https://example.com/a")
+	st.claim(a)
+	st.record(a, statusNotHTML, nil)
+	if err := st.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := LoadStateOptions(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.StartURL != opts.StartURL || loaded.MaxDepth != 3 {
+		t.Fatalf("loaded options = %+v", loaded)
+	}
+	loaded.Resume = true
+	st, frontier, err = openState(loaded, start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.close()
+
+	if len(frontier) != 1 || frontier[0].u.String() != "https://example.com/b" || frontier[0].depth != 2 {
+		t.Fatalf("resumed frontier = %+v, want only /b at depth 2", frontier)
+	}
+	if got := st.pageCount(); got != 1 {
+		t.Errorf("pageCount = %d, want 1", got)
+	}
+	if st.claim(start) {
+		t.Error("start URL should already be visited")
+	}
+	if _, dup := st.record(mustParse(t, "https://example.com/copy"), statusSaved, []byte("<html>home</html>")); !dup {
+		t.Error("content hash from previous run should be remembered")
+	}
+}
@@ -0,0 +1,236 @@
+package crawl
+
+import (
+	"container/heap"
+	"fmt"
+	"math/rand/v2"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Frontier ordering strategies.
+const (
+	StrategyBFS       = "bfs"        // breadth-first: URLs in the order they were found
+	StrategyDFS       = "dfs"        // depth-first: the URL found last comes first
+	StrategyBestFirst = "best-first" // the URL with the highest score by Scoring comes first
+	StrategyRandom    = "random"     // a random waiting URL, to sample a large site
+)
+
+// Candidate is a URL waiting in the frontier.
+type Candidate struct {
+	URL      *url.URL
+	Depth    int
+	Referrer string
+	InLinks  int     // links to the URL found so far, see Relinker
+	Priority float64 // its sitemap <priority>, 0 when it was not listed
+}
+
+// Order holds the URLs waiting to be crawled from one host and decides which
+// comes next. Each host gets its own Order, and hosts take turns. Pop is only
+// called when Len is above 0; calls are serialized.
+type Order interface {
+	Push(Candidate)
+	Pop() Candidate
+	Len() int
+}
+
+// Relinker is implemented by Orders that rank by Candidate.InLinks. A URL is
+// pushed once while it waits; when another link to it is found, Relink gets
+// the URL as pushed and its new in-link count.
+type Relinker interface {
+	Relink(u *url.URL, inLinks int)
+}
+
+// Scoring rates URLs for StrategyBestFirst; higher scores are crawled first.
+// A URL scores the weights of the Patterns it matches, plus DepthWeight per
+// link level, InLinkWeight per link to it and SitemapWeight times its sitemap
+// priority.
+type Scoring struct {
+	Patterns      []PatternWeight
+	DepthWeight   float64
+	InLinkWeight  float64
+	SitemapWeight float64
+}
+
+// PatternWeight adds Weight to the score of URLs matching Pattern, a glob or
+// "re:" regular expression as for Options.Include.
+type PatternWeight struct {
+	Pattern string
+	Weight  float64
+}
+
+// DefaultScoring prefers shallow pages that many others link to, and pages
+// the sitemap ranks high.
+var DefaultScoring = Scoring{DepthWeight: -1, InLinkWeight: 0.5, SitemapWeight: 2}
+
+// ParsePatternWeight parses "pattern=weight", e.g. "/blog/**=5" or
+// "re:/tag/=-3".
+func ParsePatternWeight(s string) (PatternWeight, error) {
+	i := strings.LastIndex(s, "=")
+	if i <= 0 {
+		return PatternWeight{}, fmt.Errorf("invalid score %q, want pattern=weight", s)
+	}
+	w, err := strconv.ParseFloat(s[i+1:], 64)
+	if err != nil {
+		return PatternWeight{}, fmt.Errorf("invalid score %q: weight: %w", s, err)
+	}
+	return PatternWeight{Pattern: s[:i], Weight: w}, nil
+}
+
+// scorer is a compiled Scoring.
+type scorer struct {
+	Scoring
+	patterns []*pattern
+}
+
+func (s Scoring) compile() (*scorer, error) {
+	sc := &scorer{Scoring: s}
+	for _, pw := range s.Patterns {
+		p, err := compilePatterns([]string{pw.Pattern})
+		if err != nil {
+			return nil, err
+		}
+		sc.patterns = append(sc.patterns, p...)
+	}
+	return sc, nil
+}
+
+// score returns the score of c.
+func (s *scorer) score(c Candidate) float64 {
+	score := s.DepthWeight*float64(c.Depth) + s.InLinkWeight*float64(c.InLinks) + s.SitemapWeight*c.Priority
+	for i, p := range s.patterns {
+		if p.match(c.URL) {
+			score += s.Patterns[i].Weight
+		}
+	}
+	return score
+}
+
+// orderFunc returns what makes each host's Order.
+func (o Options) orderFunc() (func() Order, error) {
+	if o.NewOrder != nil {
+		return o.NewOrder, nil
+	}
+	switch o.Strategy {
+	case "", StrategyBFS:
+		return func() Order { return &fifoOrder{} }, nil
+	case StrategyDFS:
+		return func() Order { return &lifoOrder{} }, nil
+	case StrategyRandom:
+		return func() Order { return &randomOrder{} }, nil
+	case StrategyBestFirst:
+		scoring := DefaultScoring
+		if o.Scoring != nil {
+			scoring = *o.Scoring
+		}
+		sc, err := scoring.compile()
+		if err != nil {
+			return nil, err
+		}
+		return func() Order { return &bestOrder{score: sc.score, byURL: make(map[*url.URL]*scored)} }, nil
+	}
+	return nil, fmt.Errorf("invalid strategy %q, expected %s, %s, %s or %s", o.Strategy, StrategyBFS, StrategyDFS, StrategyBestFirst, StrategyRandom)
+}
+
+type fifoOrder struct{ items []Candidate }
+
+func (q *fifoOrder) Push(c Candidate) { q.items = append(q.items, c) }
+func (q *fifoOrder) Len() int         { return len(q.items) }
+func (q *fifoOrder) Pop() Candidate {
+	c := q.items[0]
+	q.items[0] = Candidate{}
+	q.items = q.items[1:]
+	return c
+}
+
+type lifoOrder struct{ items []Candidate }
+
+func (q *lifoOrder) Push(c Candidate) { q.items = append(q.items, c) }
+func (q *lifoOrder) Len() int         { return len(q.items) }
+func (q *lifoOrder) Pop() Candidate {
+	n := len(q.items) - 1
+	c := q.items[n]
+	q.items[n] = Candidate{}
+	q.items = q.items[:n]
+	return c
+}
+
+type randomOrder struct{ items []Candidate }
+
+func (q *randomOrder) Push(c Candidate) { q.items = append(q.items, c) }
+func (q *randomOrder) Len() int         { return len(q.items) }
+func (q *randomOrder) Pop() Candidate {
+	i, n := rand.N(len(q.items)), len(q.items)-1
+	c := q.items[i]
+	q.items[i] = q.items[n]
+	q.items[n] = Candidate{}
+	q.items = q.items[:n]
+	return c
+}
+
+// bestOrder is a max-heap by score; equal scores keep the order found. A
+// relinked URL is rescored in place.
+type bestOrder struct {
+	score func(Candidate) float64
+	items scoredHeap
+	byURL map[*url.URL]*scored
+	seq   uint64
+}
+
+type scored struct {
+	c     Candidate
+	score float64
+	seq   uint64
+	index int // in the heap
+}
+
+type scoredHeap []*scored
+
+func (h scoredHeap) Len() int { return len(h) }
+func (h scoredHeap) Less(i, j int) bool {
+	if h[i].score != h[j].score {
+		return h[i].score > h[j].score
+	}
+	return h[i].seq < h[j].seq
+}
+func (h scoredHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index, h[j].index = i, j
+}
+func (h *scoredHeap) Push(x any) {
+	s := x.(*scored)
+	s.index = len(*h)
+	*h = append(*h, s)
+}
+func (h *scoredHeap) Pop() any {
+	old := *h
+	n := len(old) - 1
+	x := old[n]
+	old[n] = nil
+	*h = old[:n]
+	return x
+}
+
+func (q *bestOrder) Push(c Candidate) {
+	q.seq++
+	s := &scored{c: c, score: q.score(c), seq: q.seq}
+	q.byURL[c.URL] = s
+	heap.Push(&q.items, s)
+}
+func (q *bestOrder) Len() int { return len(q.items) }
+func (q *bestOrder) Pop() Candidate {
+	s := heap.Pop(&q.items).(*scored)
+	delete(q.byURL, s.c.URL)
+	return s.c
+}
+
+func (q *bestOrder) Relink(u *url.URL, inLinks int) {
+	s := q.byURL[u]
+	if s == nil {
+		return
+	}
+	s.c.InLinks = inLinks
+	s.score = q.score(s.c)
+	heap.Fix(&q.items, s.index)
+}
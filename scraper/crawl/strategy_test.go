@@ -0,0 +1,134 @@
+package crawl
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestFrontierOrdersByStrategy(t *testing.T) {
+	scoring := &Scoring{Patterns: []PatternWeight{{Pattern: "/blog/**", Weight: 5}}, DepthWeight: -1, InLinkWeight: 1, SitemapWeight: 10}
+	items := []queueItem{
+		{u: mustParse(t, "https://example.com/a"), depth: 1},
+		{u: mustParse(t, "https://example.com/blog/x"), depth: 2},
+		{u: mustParse(t, "https://example.com/b"), depth: 1},
+		{u: mustParse(t, "https://example.com/listed"), depth: 0, priority: 0.8},
+		{u: mustParse(t, "https://example.com/b"), depth: 1}, // a second link to /b
+		{u: mustParse(t, "https://example.com/c"), depth: 1},
+	}
+	tests := []struct {
+		strategy string
+		want     string
+	}{
+		{StrategyBFS, "a blog/x b listed c"},
+		{StrategyDFS, "c listed b blog/x a"},
+		// /b is queued once and rescored at 2 in-links: blog/x 5-2+1=4,
+		// listed 8+1=9, /b -1+2=1, others 0
+		{StrategyBestFirst, "listed blog/x b a c"},
+	}
+	for _, tc := range tests {
+		order, err := Options{Strategy: tc.strategy, Scoring: scoring}.orderFunc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := newFrontier(0, 0, func() int { return 0 }, t.TempDir())
+		f.newOrder = order
+		for _, it := range items {
+			f.push(it)
+		}
+		var got []string
+		for {
+			it, ok := f.next()
+			if !ok {
+				break
+			}
+			got = append(got, strings.TrimPrefix(it.u.Path, "/"))
+			f.done(it)
+		}
+		if strings.Join(got, " ") != tc.want {
+			t.Errorf("%s order = %v, want %s", tc.strategy, got, tc.want)
+		}
+	}
+
+	order, _ := Options{Strategy: StrategyRandom}.orderFunc()
+	q := order()
+	for i := range 50 {
+		q.Push(Candidate{Depth: i})
+	}
+	var depths []int
+	for q.Len() > 0 {
+		depths = append(depths, q.Pop().Depth)
+	}
+	if len(depths) != 50 || slices.IsSorted(depths) {
+		t.Errorf("random order = %v, want all 50 shuffled", depths)
+	}
+	slices.Sort(depths)
+	for i, d := range depths {
+		if d != i {
+			t.Fatalf("random order lost or repeated items: %v", depths)
+		}
+	}
+
+	if _, err := (Options{Strategy: "widest"}).orderFunc(); err == nil {
+		t.Error("unknown strategy accepted")
+	}
+	if _, err := ParsePatternWeight("re:/tag/=-3"); err != nil {
+		t.Error(err)
+	}
+	if _, err := ParsePatternWeight("/blog/**"); err == nil {
+		t.Error("score without weight accepted")
+	}
+}
+
+func TestCrawlBestFirstSpendsBudgetOnWeightedPages(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		if r.URL.Path != "/" {
+			w.Write([]byte("<html><body>leaf</body></html>"))
+			return
+		}
+		var links strings.Builder
+		for i := range 5 {
+			fmt.Fprintf(&links, `<a href="/nav%d">nav</a>`, i)
+		}
+		links.WriteString(`<a href="/blog/post">post</a>`)
+		w.Write([]byte("<html><body>" + links.String() + "</body></html>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	crawled := func(concurrency int, strategy string) []string {
+		out := t.TempDir()
+		opts := Options{StartURL: srv.URL + "/", MaxDepth: 1, MaxPages: 2, OutDir: out, SameHostOnly: true, Concurrency: concurrency,
+			Strategy: strategy, Scoring: &Scoring{Patterns: []PatternWeight{{Pattern: "/blog/**", Weight: 10}}}}
+		c, err := NewCrawler(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Run(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		recs, err := ReadManifest(filepath.Join(out, ManifestFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var paths []string
+		for _, rec := range recs {
+			paths = append(paths, strings.TrimPrefix(rec.URL, srv.URL))
+		}
+		return paths
+	}
+	for _, concurrency := range []int{1, 4} {
+		if got := crawled(concurrency, StrategyBestFirst); !slices.Equal(got, []string{"/", "/blog/post"}) {
+			t.Errorf("best-first with %d worker(s) crawled %v", concurrency, got)
+		}
+		if got := crawled(concurrency, StrategyBFS); !slices.Equal(got, []string{"/", "/nav0"}) {
+			t.Errorf("bfs with %d worker(s) crawled %v", concurrency, got)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Package diff compares the outputs of two crawls.
+package diff
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"scrawler/scraper/crawl"
+	"scrawler/scraper/parse"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Snapshot is the pages a crawl stored, read from its manifest.
+type Snapshot struct {
+	Dir   string                          // the output directory paths in the manifest are relative to
+	Pages map[string]crawl.ManifestRecord // the latest stored record per URL
+}
+
+// Load reads a crawl output directory, or a manifest file inside one. When a
+// manifest holds several runs, the latest record of each URL wins.
+func Load(path string) (*Snapshot, error) {
+	manifest := path
+	if fi, err := os.Stat(path); err != nil {
+		return nil, err
+	} else if fi.IsDir() {
+		manifest = filepath.Join(path, crawl.ManifestFile)
+	}
+	recs, err := crawl.ReadManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{Dir: filepath.Dir(manifest), Pages: make(map[string]crawl.ManifestRecord)}
+	for _, rec := range recs {
+		if rec.Kept() {
+			s.Pages[rec.URL] = rec
+		} else {
+			delete(s.Pages, rec.URL)
+		}
+	}
+	return s, nil
+}
+
+// Signals returns the extracted signals of a stored page: its JSON
+// extraction when there is one, else what the saved HTML yields. It returns
+// false when neither is available, e.g. for WARC-only crawls.
+func (s *Snapshot) Signals(rec crawl.ManifestRecord) (parse.Signals, bool) {
+	var sig parse.Signals
+	if strings.HasSuffix(rec.ExtractPath, ".json") {
+		if data, err := os.ReadFile(s.path(rec.ExtractPath)); err == nil && json.Unmarshal(data, &sig) == nil {
+			return sig, true
+		}
+	}
+	if rec.SavedPath == "" {
+		return sig, false
+	}
+	data, err := os.ReadFile(s.path(rec.SavedPath))
+	if err != nil {
+		return sig, false
+	}
+	text, _ := parse.ToUTF8(data, rec.ContentType)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(text))
+	if err != nil {
+		return sig, false
+	}
+	pageURL := rec.URL
+	if rec.FinalURL != "" {
+		pageURL = rec.FinalURL
+	}
+	return parse.ExtractSignals(doc, pageURL), true
+}
+
+func (s *Snapshot) path(rel string) string {
+	return filepath.Join(s.Dir, filepath.FromSlash(rel))
+}
+
+// Result is the difference between two crawls, A the older and B the newer.
+type Result struct {
+	A         string   `json:"a"`
+	B         string   `json:"b"`
+	Added     []string `json:"added"`   // stored by B only
+	Removed   []string `json:"removed"` // stored by A only
+	Changed   []Page   `json:"changed"` // stored by both, with different content
+	Unchanged int      `json:"unchanged"`
+}
+
+// Page is a page whose content hash differs between the crawls. Fields holds
+// the extracted signals that differ; it is empty when only markup changed,
+// and Note says why when the signals could not be compared.
+type Page struct {
+	URL       string  `json:"url"`
+	OldSHA256 string  `json:"old_sha256"`
+	NewSHA256 string  `json:"new_sha256"`
+	Fields    []Field `json:"fields,omitempty"`
+	Note      string  `json:"note,omitempty"`
+}
+
+// Field is the line diff of one part of parse.Signals.
+type Field struct {
+	Name  string `json:"name"` // "title", "headings", "paragraphs" or "links"
+	Lines []Line `json:"lines"`
+}
+
+// Line is a removed ("-") or added ("+") line.
+type Line struct {
+	Op   string `json:"op"`
+	Text string `json:"text"`
+}
+
+// Compare diffs two snapshots. URLs are sorted within each list.
+func Compare(a, b *Snapshot) *Result {
+	res := &Result{A: a.Dir, B: b.Dir, Added: []string{}, Removed: []string{}, Changed: []Page{}}
+	for u := range b.Pages {
+		if _, ok := a.Pages[u]; !ok {
+			res.Added = append(res.Added, u)
+		}
+	}
+	for u, old := range a.Pages {
+		cur, ok := b.Pages[u]
+		switch {
+		case !ok:
+			res.Removed = append(res.Removed, u)
+		case old.SHA256 == cur.SHA256:
+			res.Unchanged++
+		default:
+			res.Changed = append(res.Changed, comparePage(a, b, old, cur))
+		}
+	}
+	slices.Sort(res.Added)
+	slices.Sort(res.Removed)
+	slices.SortFunc(res.Changed, func(x, y Page) int { return strings.Compare(x.URL, y.URL) })
+	return res
+}
+
+func comparePage(a, b *Snapshot, old, cur crawl.ManifestRecord) Page {
+	p := Page{URL: old.URL, OldSHA256: old.SHA256, NewSHA256: cur.SHA256}
+	oldSig, ok1 := a.Signals(old)
+	curSig, ok2 := b.Signals(cur)
+	if !ok1 || !ok2 {
+		p.Note = "no saved HTML or JSON extraction to compare"
+		return p
+	}
+	fields := []struct {
+		name     string
+		old, cur []string
+	}{
+		{"title", lines(oldSig.Title), lines(curSig.Title)},
+		{"headings", oldSig.Headings, curSig.Headings},
+		{"paragraphs", oldSig.Paragraphs, curSig.Paragraphs},
+		{"links", oldSig.Links, curSig.Links},
+	}
+	for _, f := range fields {
+		if d := diffLines(f.old, f.cur); len(d) > 0 {
+			p.Fields = append(p.Fields, Field{Name: f.name, Lines: d})
+		}
+	}
+	return p
+}
+
+func lines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return []string{s}
+}
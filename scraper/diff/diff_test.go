@@ -0,0 +1,105 @@
+package diff
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"scrawler/scraper/crawl"
+)
+
+// writeCrawl lays out an output directory with a saved page per URL.
+func writeCrawl(t *testing.T, pages map[string]string, extra ...crawl.ManifestRecord) string {
+	t.Helper()
+	dir := t.TempDir()
+	var b strings.Builder
+	enc := json.NewEncoder(&b)
+	i := 0
+	for u, html := range pages {
+		rel := filepath.ToSlash(filepath.Join("pages", string(rune('a'+i))+".html"))
+		i++
+		if err := os.MkdirAll(filepath.Join(dir, "pages"), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, rel), []byte(html), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		enc.Encode(crawl.ManifestRecord{URL: u, Status: "saved", SHA256: html, SavedPath: rel, ContentType: "text/html"})
+	}
+	for _, rec := range extra {
+		enc.Encode(rec)
+	}
+	if err := os.WriteFile(filepath.Join(dir, crawl.ManifestFile), []byte(b.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestCompare(t *testing.T) {
+	a := writeCrawl(t, map[string]string{
+		"https://example.com/":     `<title>Home</title><h1>Welcome</h1><p>the first paragraph stays</p><p>the second paragraph goes</p><a href="/x">x</a>`,
+		"https://example.com/same": `<p>same</p>`,
+		"https://example.com/old":  `<p>old</p>`,
+		"https://example.com/css":  `<p style="a">styled</p>`,
+		"https://example.com/warc": ``,
+	})
+	b := writeCrawl(t, map[string]string{
+		"https://example.com/":     `<title>Home page</title><h1>Welcome</h1><p>the first paragraph stays</p><p>a third paragraph arrives</p><a href="/x">x</a><a href="/y">y</a>`,
+		"https://example.com/same": `<p>same</p>`,
+		"https://example.com/new":  `<p>new</p>`,
+		"https://example.com/css":  `<p style="b">styled</p>`,
+	}, crawl.ManifestRecord{URL: "https://example.com/warc", Status: "saved", SHA256: "changed"})
+
+	sa, err := Load(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sb, err := Load(filepath.Join(b, crawl.ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := Compare(sa, sb)
+	if !reflect.DeepEqual(res.Added, []string{"https://example.com/new"}) || !reflect.DeepEqual(res.Removed, []string{"https://example.com/old"}) || res.Unchanged != 1 {
+		t.Fatalf("added %v, removed %v, unchanged %d", res.Added, res.Removed, res.Unchanged)
+	}
+	if len(res.Changed) != 3 {
+		t.Fatalf("changed = %+v", res.Changed)
+	}
+	home, css, warc := res.Changed[0], res.Changed[1], res.Changed[2]
+	want := []Field{
+		{Name: "title", Lines: []Line{{"-", "Home"}, {"+", "Home page"}}},
+		{Name: "paragraphs", Lines: []Line{{"-", "the second paragraph goes"}, {"+", "a third paragraph arrives"}}},
+		{Name: "links", Lines: []Line{{"+", "https://example.com/y"}}},
+	}
+	if home.URL != "https://example.com/" || !reflect.DeepEqual(home.Fields, want) {
+		t.Errorf("home diff = %+v", home)
+	}
+	if css.URL != "https://example.com/css" || len(css.Fields) != 0 || css.Note != "" {
+		t.Errorf("markup-only diff = %+v", css)
+	}
+	if warc.Note == "" {
+		t.Errorf("page without saved HTML = %+v", warc)
+	}
+
+	md := RenderMarkdown(res)
+	for _, s := range []string{"1 added, 1 removed, 3 changed, 1 unchanged", "### https://example.com/", "- the second paragraph goes\n+ a third paragraph arrives\n"} {
+		if !strings.Contains(md, s) {
+			t.Errorf("markdown lacks %q:\n%s", s, md)
+		}
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"a", "b", "c", "d"}
+	b := []string{"a", "c", "d", "e"}
+	want := []Line{{"-", "b"}, {"+", "e"}}
+	if got := diffLines(a, b); !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLines = %v, want %v", got, want)
+	}
+	if got := diffLines(a, a); len(got) != 0 {
+		t.Errorf("diffLines of equal input = %v", got)
+	}
+}
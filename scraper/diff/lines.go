@@ -0,0 +1,56 @@
+package diff
+
+// maxLCSCells bounds the table diffLines builds; longer inputs are reported
+// as replaced wholesale.
+const maxLCSCells = 4 << 20
+
+// diffLines returns the lines removed from a and added in b, in order, based
+// on their longest common subsequence. Unchanged lines are left out.
+func diffLines(a, b []string) []Line {
+	// trim the common prefix and suffix, which is most of a typical page
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	var out []Line
+	if len(a)*len(b) > maxLCSCells {
+		for _, s := range a {
+			out = append(out, Line{Op: "-", Text: s})
+		}
+		for _, s := range b {
+			out = append(out, Line{Op: "+", Text: s})
+		}
+		return out
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i, j = i+1, j+1
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			out = append(out, Line{Op: "-", Text: a[i]})
+			i++
+		default:
+			out = append(out, Line{Op: "+", Text: b[j]})
+			j++
+		}
+	}
+	return out
+}
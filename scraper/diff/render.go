@@ -0,0 +1,54 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// RenderJSON returns the result as indented JSON.
+func RenderJSON(res *Result) ([]byte, error) {
+	return json.MarshalIndent(res, "", "  ")
+}
+
+// RenderMarkdown returns the result as a Markdown report with a diff block
+// per changed field.
+func RenderMarkdown(res *Result) string {
+	var b strings.Builder
+	b.WriteString("# Crawl diff\n\n")
+	fmt.Fprintf(&b, "`%s` → `%s`: %d added, %d removed, %d changed, %d unchanged\n\n",
+		res.A, res.B, len(res.Added), len(res.Removed), len(res.Changed), res.Unchanged)
+	for _, list := range []struct {
+		title string
+		urls  []string
+	}{{"Added", res.Added}, {"Removed", res.Removed}} {
+		if len(list.urls) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "## %s\n\n", list.title)
+		for _, u := range list.urls {
+			b.WriteString("- " + u + "\n")
+		}
+		b.WriteString("\n")
+	}
+	if len(res.Changed) > 0 {
+		b.WriteString("## Changed\n\n")
+	}
+	for _, p := range res.Changed {
+		fmt.Fprintf(&b, "### %s\n\n", p.URL)
+		switch {
+		case p.Note != "":
+			b.WriteString("_" + p.Note + "_\n\n")
+		case len(p.Fields) == 0:
+			b.WriteString("_markup only; the extracted text is the same_\n\n")
+		}
+		for _, f := range p.Fields {
+			fmt.Fprintf(&b, "**%s**\n\n```diff\n", f.Name)
+			for _, l := range f.Lines {
+				b.WriteString(l.Op + " " + l.Text + "\n")
+			}
+			b.WriteString("```\n\n")
+		}
+	}
+	return b.String()
+}
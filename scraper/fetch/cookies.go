@@ -0,0 +1,258 @@
+package fetch
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Jar is an http.CookieJar that rejects cookies set for public suffixes such
+// as "co.uk" and keeps enough about each cookie to write it back out in the
+// Netscape cookies.txt format read by curl, wget and browser extensions.
+type Jar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	entries map[string]jarEntry // by jarEntry.key
+}
+
+type jarEntry struct {
+	domain   string // without a leading dot
+	hostOnly bool   // set without a Domain attribute
+	path     string
+	secure   bool
+	httpOnly bool
+	expires  time.Time // zero for session cookies
+	name     string
+	value    string
+}
+
+// key tells entries apart as the underlying jar does: a host-only cookie and a
+// Domain cookie of the same name are two cookies.
+func (e jarEntry) key() string {
+	return e.domain + ";" + strconv.FormatBool(e.hostOnly) + ";" + e.path + ";" + e.name
+}
+
+// NewJar returns an empty Jar.
+func NewJar() *Jar {
+	jar, _ := cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	return &Jar{jar: jar, entries: make(map[string]jarEntry)}
+}
+
+// Cookies implements http.CookieJar.
+func (j *Jar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// SetCookies implements http.CookieJar.
+func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	for _, c := range cookies {
+		e := jarEntry{
+			domain:   strings.ToLower(strings.TrimPrefix(c.Domain, ".")),
+			path:     c.Path,
+			secure:   c.Secure,
+			httpOnly: c.HttpOnly,
+			name:     c.Name,
+			value:    c.Value,
+		}
+		if e.domain == "" {
+			e.domain, e.hostOnly = strings.ToLower(u.Hostname()), true
+		} else if ps, _ := publicsuffix.PublicSuffix(e.domain); ps == e.domain {
+			// the jar keeps such a cookie for the host alone, if it is that host
+			e.hostOnly = true
+		}
+		if e.path == "" || e.path[0] != '/' {
+			e.path = defaultCookiePath(u.Path)
+		}
+		switch {
+		case c.MaxAge > 0:
+			e.expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		case c.MaxAge == 0 && !c.Expires.IsZero():
+			e.expires = c.Expires
+		}
+		key := e.key()
+		if c.MaxAge < 0 || (!e.expires.IsZero() && !e.expires.After(now)) {
+			delete(j.entries, key)
+			continue
+		}
+		if j.accepted(e) {
+			j.entries[key] = e
+		}
+	}
+}
+
+// accepted reports whether the underlying jar took the cookie, which it does
+// not for a Domain the request host is outside of or a public suffix.
+func (j *Jar) accepted(e jarEntry) bool {
+	probe := &url.URL{Scheme: "https", Host: e.domain, Path: e.path}
+	for _, c := range j.jar.Cookies(probe) {
+		if c.Name == e.name && c.Value == e.value {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultCookiePath is the path a cookie without one applies to, per RFC 6265
+// section 5.1.4.
+func defaultCookiePath(p string) string {
+	if p == "" || p[0] != '/' {
+		return "/"
+	}
+	i := strings.LastIndex(p, "/")
+	if i == 0 {
+		return "/"
+	}
+	return p[:i]
+}
+
+// AddCookie sets a cookie given in Set-Cookie syntax, e.g. "session=abc" or
+// "session=abc; Domain=example.com; Path=/app", as if u had sent it.
+func (j *Jar) AddCookie(u *url.URL, setCookie string) error {
+	c, err := http.ParseSetCookie(setCookie)
+	if err != nil {
+		return fmt.Errorf("cookie %q: %w", setCookie, err)
+	}
+	if c.Path == "" {
+		// a cookie given by hand is meant for the whole site
+		c.Path = "/"
+	}
+	j.SetCookies(u, []*http.Cookie{c})
+	return nil
+}
+
+// LoadFile adds the cookies of a cookies.txt file. A missing file is not an
+// error, so the same path can be used to save the cookies afterwards.
+func (j *Jar) LoadFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	if err := j.Load(f); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// Load adds the cookies of a Netscape cookies.txt file. Each line holds
+// domain, include-subdomains flag, path, secure flag, expiry as Unix time (0
+// for a session cookie), name and value, separated by tabs. Expired cookies
+// are skipped.
+func (j *Jar) Load(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		httpOnly := false
+		if rest, ok := strings.CutPrefix(line, "#HttpOnly_"); ok {
+			line, httpOnly = rest, true
+		}
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) == 6 {
+			fields = append(fields, "") // an empty value
+		}
+		if len(fields) != 7 {
+			return fmt.Errorf("line %d: want 7 tab-separated fields, got %d", n, len(fields))
+		}
+		expiry, err := strconv.ParseInt(fields[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("line %d: bad expiry %q", n, fields[4])
+		}
+		host := strings.TrimPrefix(fields[0], ".")
+		c := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if strings.EqualFold(fields[1], "TRUE") {
+			if ps, icann := publicsuffix.PublicSuffix(host); ps == host && icann {
+				// no site may set a cookie for all of "co.uk"
+				continue
+			}
+			c.Domain = host
+		}
+		if expiry > 0 {
+			c.Expires = time.Unix(expiry, 0)
+		}
+		scheme := "http"
+		if c.Secure {
+			scheme = "https"
+		}
+		j.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: c.Path}, []*http.Cookie{c})
+	}
+	return scanner.Err()
+}
+
+// Save writes the jar's unexpired cookies, session cookies included, to a
+// cookies.txt file readable only by the current user.
+func (j *Jar) Save(path string) error {
+	var buf bytes.Buffer
+	if err := j.Write(&buf); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Write writes the jar's unexpired cookies in the cookies.txt format.
+func (j *Jar) Write(w io.Writer) error {
+	j.mu.Lock()
+	entries := make([]jarEntry, 0, len(j.entries))
+	now := time.Now()
+	for _, e := range j.entries {
+		if e.expires.IsZero() || e.expires.After(now) {
+			entries = append(entries, e)
+		}
+	}
+	j.mu.Unlock()
+	slices.SortFunc(entries, func(a, b jarEntry) int {
+		return strings.Compare(a.key(), b.key())
+	})
+
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n")
+	for _, e := range entries {
+		domain, sub := e.domain, "FALSE"
+		if !e.hostOnly {
+			domain, sub = "."+e.domain, "TRUE"
+		}
+		if e.httpOnly {
+			domain = "#HttpOnly_" + domain
+		}
+		var expiry int64
+		if !e.expires.IsZero() {
+			expiry = e.expires.Unix()
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, sub, e.path, strings.ToUpper(strconv.FormatBool(e.secure)), expiry, e.name, e.value)
+	}
+	return bw.Flush()
+}
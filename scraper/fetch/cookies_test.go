@@ -0,0 +1,120 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJarCookiesTxtRoundTrip(t *testing.T) {
+	future := time.Now().Add(time.Hour).Unix()
+	in := "# Netscape HTTP Cookie File\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tlang\ten\n" +
+		"#HttpOnly_shop.example.com\tFALSE\t/cart\tTRUE\t" + strconv.FormatInt(future, 10) + "\tsid\tabc\n" +
+		"old.example.com\tFALSE\t/\tFALSE\t1\tstale\tx\n" +
+		".co.uk\tTRUE\t/\tFALSE\t0\tsuper\tcookie\n"
+	j := NewJar()
+	if err := j.Load(strings.NewReader(in)); err != nil {
+		t.Fatal(err)
+	}
+
+	cookies := func(raw string) string {
+		u, _ := url.Parse(raw)
+		var names []string
+		for _, c := range j.Cookies(u) {
+			names = append(names, c.Name+"="+c.Value)
+		}
+		return strings.Join(names, ";")
+	}
+	if got := cookies("https://shop.example.com/cart/1"); got != "sid=abc;lang=en" {
+		t.Errorf("shop cookies = %q", got)
+	}
+	if got := cookies("http://shop.example.com/cart/1"); got != "lang=en" {
+		t.Errorf("plain http cookies = %q, want no secure cookie", got)
+	}
+	if got := cookies("https://www.example.com/"); got != "lang=en" {
+		t.Errorf("subdomain cookies = %q", got)
+	}
+	if got := cookies("https://foo.co.uk/"); got != "" {
+		t.Errorf("public suffix cookie accepted: %q", got)
+	}
+
+	var out strings.Builder
+	if err := j.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tlang\ten\n" +
+		"#HttpOnly_shop.example.com\tFALSE\t/cart\tTRUE\t" + strconv.FormatInt(future, 10) + "\tsid\tabc\n"
+	if out.String() != want {
+		t.Errorf("Write =\n%s\nwant\n%s", out.String(), want)
+	}
+
+	if err := j.Load(strings.NewReader("example.com\tTRUE\t/\n")); err == nil {
+		t.Error("short line accepted")
+	}
+}
+
+func TestJarKeepsHostOnlyAndDomainCookiesApart(t *testing.T) {
+	j := NewJar()
+	u, _ := url.Parse("https://example.com/")
+	for _, sc := range []string{"id=host", "id=domain; Domain=example.com", "id=host2"} {
+		if err := j.AddCookie(u, sc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out strings.Builder
+	if err := j.Write(&out); err != nil {
+		t.Fatal(err)
+	}
+	want := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tid\tdomain\n" +
+		"example.com\tFALSE\t/\tFALSE\t0\tid\thost2\n"
+	if out.String() != want {
+		t.Errorf("Write =\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func TestFetcherKeepsSessionCookies(t *testing.T) {
+	var robotsCookie, pageCookie string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		robotsCookie = r.Header.Get("Cookie")
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+		w.Write([]byte("ok"))
+	})
+	mux.HandleFunc("/private", func(w http.ResponseWriter, r *http.Request) {
+		pageCookie = r.Header.Get("Cookie")
+		w.Write([]byte("ok"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	client := NewHTTPClient(5)
+	u, _ := url.Parse(srv.URL)
+	if err := client.Jar.(*Jar).AddCookie(u, "lang=en"); err != nil {
+		t.Fatal(err)
+	}
+	f := NewFetcher(client, Config{})
+	ctx := context.Background()
+	for _, p := range []string{"/login", "/private"} {
+		if _, err := f.Fetch(ctx, srv.URL+p, "testbot"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if robotsCookie != "lang=en" {
+		t.Errorf("robots.txt Cookie = %q, want lang=en", robotsCookie)
+	}
+	if pageCookie != "lang=en; session=s1" {
+		t.Errorf("page Cookie = %q, want lang=en; session=s1", pageCookie)
+	}
+}
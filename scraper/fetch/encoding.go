@@ -0,0 +1,111 @@
+package fetch
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding is sent with every page request. Setting it ourselves turns
+// off the transport's implicit gzip handling, so decodeBody covers gzip too.
+const acceptEncoding = "gzip, deflate, br, zstd"
+
+// UnsupportedEncodingError is returned for a Content-Encoding the fetcher
+// cannot decode.
+type UnsupportedEncodingError struct {
+	URL      string
+	Encoding string
+}
+
+func (e *UnsupportedEncodingError) Error() string {
+	return "unsupported content encoding " + e.Encoding + " for " + e.URL
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decodeBody wraps resp.Body in decoders for its Content-Encoding, undoing
+// the codings in the reverse of the order they were applied. The returned
+// counter sees the body as it came off the wire. Once decoded, the
+// Content-Encoding and Content-Length headers are dropped, as the transport
+// does for the gzip it handles itself. Responses that never have content,
+// such as a 304 repeating the Content-Encoding of the cached entity, are left
+// as they are.
+func decodeBody(targetURL string, resp *http.Response) (io.Reader, *countingReader, []io.Closer, error) {
+	wire := &countingReader{r: resp.Body}
+	var r io.Reader = wire
+	var closers []io.Closer
+	if !hasContent(resp) {
+		return r, wire, closers, nil
+	}
+	codings := strings.Split(resp.Header.Get("Content-Encoding"), ",")
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				return nil, nil, closers, fmt.Errorf("gzip body: %w", err)
+			}
+			r, closers = zr, append(closers, zr)
+		case "deflate":
+			// "deflate" is meant to be zlib-wrapped, but some servers send raw DEFLATE
+			br := bufio.NewReader(r)
+			if head, err := br.Peek(2); err == nil && (uint(head[0])<<8|uint(head[1]))%31 == 0 && head[0]&0x0f == 8 {
+				zr, err := zlib.NewReader(br)
+				if err != nil {
+					return nil, nil, closers, fmt.Errorf("deflate body: %w", err)
+				}
+				r, closers = zr, append(closers, zr)
+			} else {
+				fr := flate.NewReader(br)
+				r, closers = fr, append(closers, fr)
+			}
+		case "br":
+			r = brotli.NewReader(r)
+		case "zstd":
+			zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+			if err != nil {
+				return nil, nil, closers, fmt.Errorf("zstd body: %w", err)
+			}
+			r, closers = zr, append(closers, zr.IOReadCloser())
+		default:
+			return nil, nil, closers, &UnsupportedEncodingError{URL: targetURL, Encoding: coding}
+		}
+	}
+	if r != io.Reader(wire) {
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+	return r, wire, closers, nil
+}
+
+// hasContent reports whether a response may carry content at all (RFC 9110
+// section 6.4.1).
+func hasContent(resp *http.Response) bool {
+	if resp.Request != nil && resp.Request.Method == http.MethodHead {
+		return false
+	}
+	return resp.StatusCode >= 200 && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusNotModified
+}
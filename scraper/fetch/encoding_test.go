@@ -0,0 +1,129 @@
+package fetch
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestFetchDecodesContentEncodings(t *testing.T) {
+	body := "<html><body>" + strings.Repeat("<p>compressible</p>", 200) + "</body></html>"
+	compress := map[string]func(io.Writer) io.WriteCloser{
+		"gzip":    func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"deflate": func(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) },
+		"br":      func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(w)
+			return zw
+		},
+		"rawdeflate": func(w io.Writer) io.WriteCloser {
+			fw, _ := flate.NewWriter(w, flate.DefaultCompression)
+			return fw
+		},
+	}
+	var gotAccept string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", http.NotFound)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		gotAccept = r.Header.Get("Accept-Encoding")
+		coding := strings.TrimPrefix(r.URL.Path, "/")
+		w.Header().Set("Content-Type", "text/html")
+		if coding == "identity" {
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Encoding", strings.TrimPrefix(coding, "raw"))
+		if coding == "gzip,br" {
+			var inner bytes.Buffer
+			zw := gzip.NewWriter(&inner)
+			zw.Write([]byte(body))
+			zw.Close()
+			bw := brotli.NewWriter(w)
+			bw.Write(inner.Bytes())
+			bw.Close()
+			return
+		}
+		if coding == "compress" {
+			w.Write([]byte("\x1f\x9d"))
+			return
+		}
+		cw := compress[coding](w)
+		cw.Write([]byte(body))
+		cw.Close()
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := NewFetcher(srv.Client(), Config{})
+	ctx := context.Background()
+	for _, coding := range []string{"gzip", "deflate", "rawdeflate", "br", "zstd", "gzip,br"} {
+		page, err := f.Fetch(ctx, srv.URL+"/"+coding, "testbot")
+		if err != nil {
+			t.Fatalf("%s: %v", coding, err)
+		}
+		if string(page.Body) != body {
+			t.Errorf("%s: body not decoded: %q", coding, page.Body[:min(len(page.Body), 40)])
+		}
+		if page.ContentEncoding != strings.TrimPrefix(coding, "raw") || page.Header.Get("Content-Encoding") != "" {
+			t.Errorf("%s: content encoding = %q, header %q", coding, page.ContentEncoding, page.Header.Get("Content-Encoding"))
+		}
+		if page.WireBytes <= 0 || page.WireBytes >= int64(len(page.Body)) {
+			t.Errorf("%s: wire bytes = %d for %d decoded", coding, page.WireBytes, len(page.Body))
+		}
+	}
+	if gotAccept != acceptEncoding {
+		t.Errorf("Accept-Encoding = %q, want %q", gotAccept, acceptEncoding)
+	}
+
+	page, err := f.Fetch(ctx, srv.URL+"/identity", "testbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.WireBytes != int64(len(body)) || page.ContentEncoding != "" {
+		t.Errorf("identity: wire bytes = %d, encoding %q", page.WireBytes, page.ContentEncoding)
+	}
+
+	var ue *UnsupportedEncodingError
+	if _, err := f.Fetch(ctx, srv.URL+"/compress", "testbot"); !errors.As(err, &ue) || ue.Encoding != "compress" {
+		t.Errorf("compress: err = %v, want *UnsupportedEncodingError", err)
+	}
+}
+
+func TestFetchIgnoresContentEncodingWithoutContent(t *testing.T) {
+	const etag = `"v1"`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", http.NotFound)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		// some servers repeat the entity headers on a 304
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+	})
+	mux.HandleFunc("/empty", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := NewFetcher(srv.Client(), Config{})
+	page, err := f.FetchIf(context.Background(), srv.URL+"/", "testbot", Validators{ETag: etag}, nil)
+	if err != nil || !page.NotModified {
+		t.Errorf("gzip-labelled 304: err %v, page %+v", err, page)
+	}
+	page, err = f.Fetch(context.Background(), srv.URL+"/empty", "testbot")
+	if err != nil || page.StatusCode != http.StatusNoContent || len(page.Body) != 0 {
+		t.Errorf("gzip-labelled 204: err %v, page %+v", err, page)
+	}
+}
@@ -1,6 +1,11 @@
 package fetch
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"net/url"
@@ -9,204 +14,565 @@ import (
 	"time"
 
 	"github.com/PuerkitoBio/goquery"
+
+	"scrawler/scraper/parse"
 )
 
-// NewHTTPClient returns an http.Client with the specified timeout seconds.
+// NewHTTPClient returns an http.Client with the specified timeout seconds and
+// an empty cookie Jar, so session cookies carry over between requests.
 func NewHTTPClient(timeoutSecs int) *http.Client {
-	return &http.Client{Timeout: time.Duration(timeoutSecs) * time.Second}
+	return &http.Client{Timeout: time.Duration(timeoutSecs) * time.Second, Jar: NewJar()}
+}
+
+// DefaultMaxRobotsDelay caps robots.txt delays when Config.MaxRobotsDelay is 0.
+const DefaultMaxRobotsDelay = 10 * time.Second
+
+// DefaultMaxRedirects is the redirect limit when Config.MaxRedirects is 0.
+const DefaultMaxRedirects = 10
+
+// Config tunes a Fetcher's politeness.
+type Config struct {
+	MinDelay time.Duration // minimum interval between requests to one host
+	// MaxRobotsDelay caps the robots.txt Crawl-delay/Request-rate; 0 means
+	// DefaultMaxRobotsDelay and a negative value ignores robots.txt delays.
+	MaxRobotsDelay time.Duration
+	Retry          RetryPolicy
+	// MaxRedirects limits the redirects followed per fetch; 0 means
+	// DefaultMaxRedirects and a negative value returns redirects as they are.
+	MaxRedirects int
+	// CheckRedirect, when set, vets every redirect target before robots.txt;
+	// an error stops the fetch and is returned wrapped in a *url.Error.
+	CheckRedirect func(to *url.URL) error
+	// MaxBodySize truncates response bodies past this many bytes; 0 reads
+	// them whole.
+	MaxBodySize int64
+	// Headers are added to every request, robots.txt included, for the hosts
+	// they match; they are re-applied on each redirect hop.
+	Headers []HeaderProfile
+	// Proxies picks the proxy of each fetch and tracks its health. The
+	// client's transport must route through it, see ProxyPool.Transport.
+	Proxies *ProxyPool
+}
+
+// Fetcher performs polite fetches. It owns a robots.txt cache and the per-host
+// throttle, backoff and circuit-breaker state, so independent crawls should use
+// separate Fetchers. It is safe for concurrent use.
+type Fetcher struct {
+	client *http.Client
+
+	mu  sync.Mutex
+	cfg Config
+
+	robotsMu sync.Mutex
+	robots   map[string]*robotsTxt // by scheme+host
+
+	hostMu sync.Mutex
+	hosts  map[string]*hostState
+}
+
+// NewFetcher returns a Fetcher using client for all requests.
+func NewFetcher(client *http.Client, cfg Config) *Fetcher {
+	return &Fetcher{
+		client: client,
+		cfg:    cfg,
+		robots: make(map[string]*robotsTxt),
+		hosts:  make(map[string]*hostState),
+	}
+}
+
+// Client returns the http.Client the Fetcher sends requests with.
+func (f *Fetcher) Client() *http.Client { return f.client }
+
+func (f *Fetcher) config() Config {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.cfg
+}
+
+// std backs the package-level functions, which take the client per call.
+var std = NewFetcher(nil, Config{Retry: DefaultRetryPolicy})
+
+func SetMinDelay(d time.Duration) {
+	std.mu.Lock()
+	std.cfg.MinDelay = d
+	std.mu.Unlock()
+}
+
+// SetMaxRobotsDelay caps the delay taken from robots.txt; 0 ignores robots.txt delays.
+func SetMaxRobotsDelay(d time.Duration) {
+	if d == 0 {
+		d = -1
+	}
+	std.mu.Lock()
+	std.cfg.MaxRobotsDelay = d
+	std.mu.Unlock()
+}
+
+func SetRetryPolicy(p RetryPolicy) {
+	std.mu.Lock()
+	std.cfg.Retry = p
+	std.mu.Unlock()
+}
+
+// Redirect is one hop of a redirect chain: URL answered with StatusCode.
+// Request and Header are the hop's request and response headers, for archival.
+type Redirect struct {
+	URL        string        `json:"url"`
+	StatusCode int           `json:"status"`
+	Request    *http.Request `json:"-"`
+	Proto      string        `json:"-"`
+	Header     http.Header   `json:"-"`
 }
 
+// Page is a fetched response together with the request that produced it.
+type Page struct {
+	URL         string
+	FinalURL    string        // after redirects; equal to URL when there were none
+	Redirects   []Redirect    // the hops that led to FinalURL, in order
+	Request     *http.Request // as sent, for archival
+	Proto       string
+	StatusCode  int
+	Status      string
+	Header      http.Header
+	Body        []byte
+	ContentType string // sniffed from the body when the server sent none
+	FetchedAt   time.Time
+	Sniffed     bool // ContentType was detected from the body
+	Truncated   bool // Body stops at Config.MaxBodySize
+	Discarded   bool // the body was not read, as its media type was not accepted
+
+	// ContentEncoding is the Content-Encoding the body was sent with; Body
+	// holds it decoded. WireBytes counts the bytes read off the connection,
+	// so it can be compared with len(Body).
+	ContentEncoding string
+	WireBytes       int64
+
+	// Proxy is the proxy the page was fetched through, with its password
+	// redacted; empty for a direct connection.
+	Proxy string
+
+	// NotModified is set when a conditional request got a 304 response;
+	// the Page then has no Body.
+	NotModified bool
+}
+
+// Validators identify the version of a page an earlier response returned.
+// Sent back with a request, they let the server answer 304 Not Modified.
+type Validators struct {
+	ETag         string
+	LastModified string
+}
+
+// IsZero reports whether there is nothing to make a request conditional on.
+func (v Validators) IsZero() bool {
+	return v.ETag == "" && v.LastModified == ""
+}
+
+// Validators returns the ETag and Last-Modified headers of the response.
+func (p *Page) Validators() Validators {
+	return Validators{ETag: p.Header.Get("ETag"), LastModified: p.Header.Get("Last-Modified")}
+}
+
+// ErrNotModified is returned by FetchDocumentIf when the page has not changed.
+var ErrNotModified = errors.New("not modified")
+
 // FetchDocument fetches a URL and returns the parsed goquery document, raw bytes, and content-type.
+// The document is parsed after converting the body to UTF-8; the raw bytes are as served.
 func FetchDocument(client *http.Client, targetURL string, userAgent string) (*goquery.Document, []byte, string, error) {
-	if !RobotsAllowed(client, targetURL, userAgent) {
-		return nil, nil, "", &RobotsBlockedError{URL: targetURL}
-	}
-	// Per-host rate limiting
-	if getMinDelay() > 0 {
-		if u, err := url.Parse(targetURL); err == nil {
-			throttle(u.Scheme + "://" + u.Host)
-		}
+	data, ctype, err := FetchRaw(client, targetURL, userAgent)
+	if err != nil {
+		return nil, nil, ctype, err
 	}
-	req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+	text, _ := parse.ToUTF8(data, ctype)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(text))
 	if err != nil {
+		return nil, nil, ctype, err
+	}
+	return doc, data, ctype, nil
+}
+
+// FetchDocumentIf is FetchDocument as a conditional request: it sends
+// If-None-Match and If-Modified-Since from v and returns ErrNotModified when
+// the server answers 304.
+func FetchDocumentIf(client *http.Client, targetURL string, userAgent string, v Validators) (*goquery.Document, []byte, string, error) {
+	page, err := std.fetch(context.Background(), client, targetURL, userAgent, v, nil, 0)
+	if page == nil {
 		return nil, nil, "", err
 	}
-	if userAgent != "" {
-		req.Header.Set("User-Agent", userAgent)
+	if err != nil {
+		return nil, nil, page.ContentType, err
 	}
-	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
-
-	resp, err := client.Do(req)
+	if page.NotModified {
+		return nil, nil, page.ContentType, ErrNotModified
+	}
+	text, _ := parse.ToUTF8(page.Body, page.ContentType)
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(text))
 	if err != nil {
-		return nil, nil, "", err
+		return nil, nil, page.ContentType, err
 	}
-	defer func(body io.ReadCloser) { _ = body.Close() }(resp.Body)
+	return doc, page.Body, page.ContentType, nil
+}
 
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, nil, resp.Header.Get("Content-Type"), err
+// FetchRaw fetches a URL honouring robots.txt, the per-host delay and the retry
+// policy, and returns the body and content-type.
+func FetchRaw(client *http.Client, targetURL string, userAgent string) ([]byte, string, error) {
+	page, err := Fetch(client, targetURL, userAgent)
+	if page == nil {
+		return nil, "", err
 	}
-	doc, err := goquery.NewDocumentFromReader(strings.NewReader(string(data)))
 	if err != nil {
-		return nil, nil, resp.Header.Get("Content-Type"), err
+		return nil, page.ContentType, err
 	}
-	return doc, data, resp.Header.Get("Content-Type"), nil
+	return page.Body, page.ContentType, nil
 }
 
-// Robots parsing and cache
-type RobotsBlockedError struct{ URL string }
+// Fetch performs a GET honouring robots.txt, the per-host delay and the retry
+// policy. A non-2xx response is returned as its Page together with an
+// *HTTPStatusError.
+func Fetch(client *http.Client, targetURL string, userAgent string) (*Page, error) {
+	return std.fetch(context.Background(), client, targetURL, userAgent, Validators{}, nil, 0)
+}
 
-func (e *RobotsBlockedError) Error() string { return "blocked by robots.txt: " + e.URL }
+// Fetch is like the package-level Fetch, using the Fetcher's client and state.
+// Cancelling ctx aborts the request and any wait for the host.
+func (f *Fetcher) Fetch(ctx context.Context, targetURL string, userAgent string) (*Page, error) {
+	return f.fetch(ctx, f.client, targetURL, userAgent, Validators{}, nil, 0)
+}
 
- // robotsCache caches robots.txt per scheme+host to avoid re-fetching every request.
-var robotsCache = make(map[string]*robotsTxt)
-var robotsMu sync.Mutex
+// FetchLimit is like Fetch, but truncates the body past maxBody bytes, which
+// must be above 0, instead of Config.MaxBodySize; for files such as sitemaps
+// that may be larger than pages.
+func (f *Fetcher) FetchLimit(ctx context.Context, targetURL, userAgent string, maxBody int64) (*Page, error) {
+	return f.fetch(ctx, f.client, targetURL, userAgent, Validators{}, nil, maxBody)
+}
 
-type robotsTxt struct {
-	uaRules map[string][]robotRule // by lowercase UA, "*" for default
+// FetchAccept is like Fetch, but only reads the body of a 2xx response when
+// accept reports true for its media type, e.g. "text/html". Otherwise the
+// connection is dropped after the headers and the Page is marked Discarded.
+func (f *Fetcher) FetchAccept(ctx context.Context, targetURL, userAgent string, accept func(mediaType string) bool) (*Page, error) {
+	return f.fetch(ctx, f.client, targetURL, userAgent, Validators{}, accept, 0)
 }
-type robotRule struct {
-	allow bool
-	path  string
+
+// FetchIf is FetchAccept as a conditional request on the validators of an
+// earlier response. A 304 answer is returned as a Page marked NotModified.
+func (f *Fetcher) FetchIf(ctx context.Context, targetURL, userAgent string, v Validators, accept func(mediaType string) bool) (*Page, error) {
+	return f.fetch(ctx, f.client, targetURL, userAgent, v, accept, 0)
 }
 
-// RobotsAllowed checks if URL is allowed for the given user-agent.
-func RobotsAllowed(client *http.Client, rawURL, userAgent string) bool {
-	u, err := url.Parse(rawURL)
+// fetch performs a GET; maxBody, when above 0, replaces Config.MaxBodySize.
+func (f *Fetcher) fetch(ctx context.Context, client *http.Client, targetURL string, userAgent string, since Validators, accept func(string) bool, maxBody int64) (*Page, error) {
+	if !f.robotsAllowed(ctx, client, targetURL, userAgent) {
+		return nil, &RobotsBlockedError{URL: targetURL}
+	}
+	u, err := url.Parse(targetURL)
 	if err != nil {
-		return true
+		return nil, err
 	}
 	host := u.Scheme + "://" + u.Host
-	ua := strings.ToLower(strings.TrimSpace(userAgent))
-	if ua == "" {
-		ua = "*"
+	cfg := f.config()
+	policy := cfg.Retry
+	if maxBody <= 0 {
+		maxBody = cfg.MaxBodySize
 	}
+	var proxy *url.URL // of the current attempt
+	read := func(chain []Redirect, resp *http.Response, fetchedAt time.Time) (*Page, error) {
+		page, err := readPage(targetURL, chain, resp, fetchedAt, maxBody, !since.IsZero(), accept)
+		if page != nil && proxy != nil {
+			page.Proxy = proxy.Redacted()
+		}
+		return page, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := f.checkBreaker(host); err != nil {
+			return nil, err
+		}
+		// Per-host rate limiting
+		if err := f.throttle(ctx, host, f.hostDelay(ctx, client, u, userAgent)); err != nil {
+			return nil, err
+		}
 
-	// Fast-path cache lookup without holding network calls under the lock (double-checked locking)
-	robotsMu.Lock()
-	rob := robotsCache[host]
-	robotsMu.Unlock()
-	if rob == nil {
-		fetched := fetchRobots(client, host, userAgent)
-		robotsMu.Lock()
-		if robotsCache[host] == nil {
-			robotsCache[host] = fetched
+		fetchedAt := time.Now()
+		var chain []Redirect
+		follow := *client
+		follow.CheckRedirect = f.checkRedirect(ctx, client, userAgent, &chain)
+		var pctx context.Context
+		pctx, proxy = cfg.Proxies.withProxy(ctx, u)
+		resp, err := get(pctx, &follow, targetURL, userAgent, since, cfg.Headers)
+		refused := err != nil && resp != nil
+		if refused {
+			// a redirect was refused; the body of the last response is closed
+			resp = nil
+		}
+		if ctx.Err() != nil {
+			if resp != nil {
+				resp.Body.Close()
+			}
+			return nil, ctx.Err()
 		}
-		rob = robotsCache[host]
-		robotsMu.Unlock()
+		retryAfter, retry := shouldRetry(resp, err)
+		proxyFailed := false
+		if proxy != nil {
+			// connection errors and 407s are the proxy's fault, not the
+			// host's; another attempt may go through a healthier proxy
+			proxyFailed = (err != nil && !refused) || (resp != nil && resp.StatusCode == http.StatusProxyAuthRequired)
+			cfg.Proxies.record(proxy, proxyFailed)
+			if err != nil && !refused {
+				err = &ProxyError{Proxy: proxy.Redacted(), Err: err}
+			}
+			retry = retry || proxyFailed
+		}
+		if !retry {
+			if err != nil {
+				return nil, err
+			}
+			f.recordSuccess(host)
+			return read(chain, resp, fetchedAt)
+		}
+
+		throttled := resp != nil && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable)
+		if !proxyFailed {
+			f.recordFailure(host, throttled)
+		}
+		giveUp := attempt >= policy.MaxRetries || (policy.MaxDelay > 0 && retryAfter > policy.MaxDelay)
+		if resp != nil {
+			if giveUp {
+				return read(chain, resp, fetchedAt)
+			}
+			_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+			_ = resp.Body.Close()
+		} else if giveUp {
+			return nil, fmt.Errorf("giving up after %d attempt(s): %w", attempt+1, err)
+		}
+		if proxyFailed {
+			// the next attempt goes through another proxy; the host is not to blame
+			continue
+		}
+		wait := policy.backoff(attempt)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		f.deferHost(host, wait)
 	}
-	return rob.isAllowed(ua, u.EscapedPath())
 }
 
-func fetchRobots(client *http.Client, host, userAgent string) *robotsTxt {
-	// default allow if fetch fails
-	rob := &robotsTxt{uaRules: map[string][]robotRule{"*": {}}}
-	req, _ := http.NewRequest(http.MethodGet, host+"/robots.txt", nil)
+// checkRedirect returns a CheckRedirect func applying the redirect limit,
+// Config.CheckRedirect, robots.txt and the per-host delay to every hop and
+// recording the chain.
+func (f *Fetcher) checkRedirect(ctx context.Context, client *http.Client, userAgent string, chain *[]Redirect) func(*http.Request, []*http.Request) error {
+	cfg := f.config()
+	limit := cfg.MaxRedirects
+	if limit == 0 {
+		limit = DefaultMaxRedirects
+	}
+	return func(req *http.Request, via []*http.Request) error {
+		if limit < 0 {
+			return http.ErrUseLastResponse
+		}
+		hop := Redirect{URL: via[len(via)-1].URL.String(), Request: via[len(via)-1]}
+		if req.Response != nil {
+			hop.StatusCode, hop.Proto, hop.Header = req.Response.StatusCode, req.Response.Proto, req.Response.Header
+		}
+		*chain = append(*chain, hop)
+		if len(via) > limit {
+			return fmt.Errorf("stopped after %d redirects", limit)
+		}
+		if cfg.CheckRedirect != nil {
+			if err := cfg.CheckRedirect(req.URL); err != nil {
+				return err
+			}
+		}
+		if !f.robotsAllowed(ctx, client, req.URL.String(), userAgent) {
+			return &RobotsBlockedError{URL: req.URL.String()}
+		}
+		// headers meant for the previous host must not follow the redirect
+		resetHeaders(req.Header, cfg.Headers, req.URL)
+		return f.throttle(ctx, req.URL.Scheme+"://"+req.URL.Host, f.hostDelay(ctx, client, req.URL, userAgent))
+	}
+}
+
+func get(ctx context.Context, client *http.Client, targetURL, userAgent string, since Validators, headers []HeaderProfile) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return nil, err
+	}
 	if userAgent != "" {
 		req.Header.Set("User-Agent", userAgent)
 	}
-	resp, err := client.Do(req)
-	if err != nil || resp.StatusCode >= 400 {
-		return rob
+	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	if since.ETag != "" {
+		req.Header.Set("If-None-Match", since.ETag)
 	}
-	defer resp.Body.Close()
-	data, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return rob
+	if since.LastModified != "" {
+		req.Header.Set("If-Modified-Since", since.LastModified)
 	}
-	parseRobots(string(data), rob)
-	return rob
+	applyHeaders(req.Header, headers, req.URL)
+	return client.Do(req)
 }
 
-func parseRobots(text string, rob *robotsTxt) {
-	var currentUA []string
-	lines := strings.Split(text, "\n")
-	for _, line := range lines {
-		l := strings.TrimSpace(line)
-		if l == "" || strings.HasPrefix(l, "#") {
-			continue
-		}
-		parts := strings.SplitN(l, ":", 2)
-		if len(parts) != 2 {
-			continue
+// readPage consumes and closes a response body. Error pages are size-limited
+// and reported with an *HTTPStatusError. A missing or generic Content-Type is
+// sniffed from the first bytes; when accept rejects the media type the rest of
+// the body is not read. A 304 answer to a conditional request is not an error.
+func readPage(targetURL string, chain []Redirect, resp *http.Response, fetchedAt time.Time, maxBody int64, conditional bool, accept func(string) bool) (*Page, error) {
+	defer func(body io.ReadCloser) { _ = body.Close() }(resp.Body)
+	page := &Page{
+		URL:             targetURL,
+		FinalURL:        resp.Request.URL.String(),
+		Redirects:       chain,
+		Request:         resp.Request,
+		Proto:           resp.Proto,
+		StatusCode:      resp.StatusCode,
+		Status:          resp.Status,
+		Header:          resp.Header,
+		ContentType:     resp.Header.Get("Content-Type"),
+		ContentEncoding: resp.Header.Get("Content-Encoding"),
+		FetchedAt:       fetchedAt,
+	}
+	body, wire, closers, err := decodeBody(targetURL, resp)
+	defer func() {
+		for _, c := range closers {
+			_ = c.Close()
 		}
-		key := strings.ToLower(strings.TrimSpace(parts[0]))
-		val := strings.TrimSpace(parts[1])
-		switch key {
-		case "user-agent":
-			ua := strings.ToLower(val)
-			currentUA = []string{ua}
-			if _, ok := rob.uaRules[ua]; !ok {
-				rob.uaRules[ua] = nil
-			}
-		case "allow":
-			for _, ua := range currentUA {
-				rob.uaRules[ua] = append(rob.uaRules[ua], robotRule{allow: true, path: val})
-			}
-			rob.uaRules["*"] = append(rob.uaRules["*"], robotRule{allow: true, path: val})
-		case "disallow":
-			for _, ua := range currentUA {
-				rob.uaRules[ua] = append(rob.uaRules[ua], robotRule{allow: false, path: val})
-			}
-			rob.uaRules["*"] = append(rob.uaRules["*"], robotRule{allow: false, path: val})
+	}()
+	if err != nil {
+		return nil, err
+	}
+	defer func() { page.WireBytes = wire.n }()
+	if conditional && resp.StatusCode == http.StatusNotModified {
+		page.NotModified = true
+		return page, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		limit := int64(maxErrorBody)
+		if maxBody > 0 && maxBody < limit {
+			limit = maxBody
 		}
+		page.Body, _ = io.ReadAll(io.LimitReader(body, limit))
+		return page, &HTTPStatusError{URL: targetURL, StatusCode: resp.StatusCode, Header: resp.Header, Body: page.Body}
 	}
-}
 
-func (r *robotsTxt) isAllowed(userAgent string, path string) bool {
-	// very simple longest-match: disallow beats allow on equal prefix length
-	best := 0
-	allowed := true
-	check := func(rules []robotRule) {
-		for _, rule := range rules {
-			p := rule.path
-			if p == "" {
-				continue
-			}
-			// Match only exact path or subpaths (boundary-aware), e.g., "/jobs" or "/jobs/...".
-			if path == p || strings.HasPrefix(path, strings.TrimRight(p, "/")+"/") {
-				plen := len(p)
-				if plen > best {
-					best = plen
-					allowed = rule.allow
-				} else if plen == best && allowed && !rule.allow {
-					// On equal prefix length, Disallow wins over Allow
-					allowed = false
-				}
-			}
-		}
+	br := bufio.NewReaderSize(body, sniffLen)
+	if mt := MediaType(page.ContentType); mt == "" || mt == "application/octet-stream" {
+		head, _ := br.Peek(sniffLen)
+		page.ContentType, page.Sniffed = http.DetectContentType(head), true
+	}
+	if accept != nil && !accept(MediaType(page.ContentType)) {
+		page.Discarded = true
+		return page, nil
 	}
-	if rules, ok := r.uaRules[userAgent]; ok {
-		check(rules)
+
+	var r io.Reader = br
+	if maxBody > 0 {
+		r = io.LimitReader(br, maxBody+1)
 	}
-	check(r.uaRules["*"])
-	return allowed
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if maxBody > 0 && int64(len(data)) > maxBody {
+		data, page.Truncated = data[:maxBody], true
+	}
+	page.Body = data
+	return page, nil
+}
+
+// sniffLen is how much of a body http.DetectContentType looks at.
+const sniffLen = 512
+
+// MediaType returns the lower-cased media type of a Content-Type value,
+// without parameters.
+func MediaType(contentType string) string {
+	mt, _, _ := strings.Cut(contentType, ";")
+	return strings.ToLower(strings.TrimSpace(mt))
+}
+
+// HTTPStatusError is returned for responses outside the 2xx range, after any
+// retries. Body holds the (size-limited) error page.
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
 }
 
-// Simple per-host rate limiter
-var minDelay time.Duration
-var hostLast = make(map[string]time.Time)
-var hostMu sync.Mutex
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s: HTTP %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
 
-func SetMinDelay(d time.Duration) { minDelay = d }
-func getMinDelay() time.Duration  { return minDelay }
+// maxErrorBody limits how much of an error page is kept.
+const maxErrorBody = 1 << 20
 
-func throttle(host string) {
-	if minDelay <= 0 {
-		return
+// Per-host rate limiting. The interval for a host is the larger of the minimum
+// delay, the robots.txt Crawl-delay/Request-rate (capped by MaxRobotsDelay) and
+// the host's adaptive penalty after 429/503 responses.
+type hostState struct {
+	last      time.Time     // last reserved request slot
+	notBefore time.Time     // no request before this, e.g. from Retry-After
+	penalty   time.Duration // adaptive extra interval
+	failures  int           // consecutive failed attempts
+	openUntil time.Time     // circuit breaker is open until then
+}
+
+// hostFor returns the state for host; f.hostMu must be held.
+func (f *Fetcher) hostFor(host string) *hostState {
+	hs := f.hosts[host]
+	if hs == nil {
+		hs = &hostState{}
+		f.hosts[host] = hs
+	}
+	return hs
+}
+
+func (f *Fetcher) hostDelay(ctx context.Context, client *http.Client, u *url.URL, userAgent string) time.Duration {
+	cfg := f.config()
+	d := cfg.MinDelay
+	limit := cfg.MaxRobotsDelay
+	if limit == 0 {
+		limit = DefaultMaxRobotsDelay
+	}
+	if limit > 0 {
+		rd := f.getRobots(ctx, client, u, userAgent).crawlDelay(userAgent)
+		if rd > limit {
+			rd = limit
+		}
+		if rd > d {
+			d = rd
+		}
+	}
+	return d
+}
+
+// throttle waits until host may be requested again. Each caller reserves the
+// next free slot so concurrent workers are spaced out rather than released together.
+func (f *Fetcher) throttle(ctx context.Context, host string, delay time.Duration) error {
+	f.hostMu.Lock()
+	hs := f.hostFor(host)
+	if hs.penalty > delay {
+		delay = hs.penalty
 	}
-	hostMu.Lock()
-	last := hostLast[host]
 	now := time.Now()
-	if last.IsZero() || now.Sub(last) >= minDelay {
-		hostLast[host] = now
-		hostMu.Unlock()
-		return
-	}
-	wait := minDelay - now.Sub(last)
-	hostMu.Unlock()
-	time.Sleep(wait)
-	hostMu.Lock()
-	hostLast[host] = time.Now()
-	hostMu.Unlock()
+	next := now
+	if !hs.last.IsZero() && hs.last.Add(delay).After(next) {
+		next = hs.last.Add(delay)
+	}
+	if hs.notBefore.After(next) {
+		next = hs.notBefore
+	}
+	if delay <= 0 && !next.After(now) {
+		f.hostMu.Unlock()
+		return nil
+	}
+	hs.last = next
+	f.hostMu.Unlock()
+
+	t := time.NewTimer(next.Sub(now))
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
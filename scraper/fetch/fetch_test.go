@@ -0,0 +1,178 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFetchDocumentReturnsHTTPStatusError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		w.Header().Set("X-Trace", "abc")
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("<html>missing</html>"))
+	}))
+	defer srv.Close()
+
+	doc, _, _, err := FetchDocument(srv.Client(), srv.URL+"/gone", "testbot")
+	if doc != nil {
+		t.Error("a 404 page should not be parsed")
+	}
+	var he *HTTPStatusError
+	if !errors.As(err, &he) {
+		t.Fatalf("err = %v, want HTTPStatusError", err)
+	}
+	if he.StatusCode != http.StatusNotFound || he.URL != srv.URL+"/gone" || he.Header.Get("X-Trace") != "abc" {
+		t.Errorf("HTTPStatusError = %+v", he)
+	}
+	if string(he.Body) != "<html>missing</html>" {
+		t.Errorf("Body = %q", he.Body)
+	}
+}
+
+func TestFetchRecordsRedirectChain(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	})
+	mux.Handle("/old", http.RedirectHandler("/moved", http.StatusMovedPermanently))
+	mux.Handle("/moved", http.RedirectHandler("/new", http.StatusFound))
+	mux.HandleFunc("/new", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	})
+	mux.Handle("/sneaky", http.RedirectHandler("/private/page", http.StatusFound))
+	mux.HandleFunc("/private/page", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a redirect into a disallowed path was followed")
+	})
+	mux.Handle("/loop", http.RedirectHandler("/loop", http.StatusFound))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := NewFetcher(srv.Client(), Config{MaxRedirects: 3})
+	ctx := context.Background()
+	page, err := f.Fetch(ctx, srv.URL+"/old", "testbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Redirect{{URL: srv.URL + "/old", StatusCode: 301}, {URL: srv.URL + "/moved", StatusCode: 302}}
+	var got []Redirect
+	for _, hop := range page.Redirects {
+		got = append(got, Redirect{URL: hop.URL, StatusCode: hop.StatusCode})
+	}
+	if page.FinalURL != srv.URL+"/new" || !reflect.DeepEqual(got, want) || string(page.Body) != "new" {
+		t.Errorf("page = %s %+v %q", page.FinalURL, page.Redirects, page.Body)
+	}
+	if hop := page.Redirects[0]; hop.Header.Get("Location") != "/moved" || hop.Request == nil || hop.Request.URL.String() != hop.URL || hop.Proto != "HTTP/1.1" {
+		t.Errorf("first hop = %+v, want its request and response headers", hop)
+	}
+
+	var rb *RobotsBlockedError
+	if _, err := f.Fetch(ctx, srv.URL+"/sneaky", "testbot"); !errors.As(err, &rb) {
+		t.Errorf("redirect into a disallowed path: err = %v, want RobotsBlockedError", err)
+	}
+	if _, err := f.Fetch(ctx, srv.URL+"/loop", "testbot"); err == nil || !strings.Contains(err.Error(), "stopped after 3 redirects") {
+		t.Errorf("redirect loop: err = %v", err)
+	}
+
+	f = NewFetcher(srv.Client(), Config{MaxRedirects: -1})
+	page, err = f.Fetch(ctx, srv.URL+"/old", "testbot")
+	var he *HTTPStatusError
+	if !errors.As(err, &he) || he.StatusCode != http.StatusMovedPermanently || page.FinalURL != srv.URL+"/old" {
+		t.Errorf("unfollowed redirect: page %+v, err %v", page, err)
+	}
+}
+
+func TestFetchAcceptSniffsAndLimitsBodies(t *testing.T) {
+	big := strings.Repeat("<p>filler</p>", 100)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", http.NotFound)
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(make([]byte, 1<<20))
+	})
+	mux.HandleFunc("/untyped", func(w http.ResponseWriter, r *http.Request) {
+		w.Header()["Content-Type"] = nil // keep net/http from sniffing on our behalf
+		w.Write([]byte("<!DOCTYPE html><html><body>" + big + "</body></html>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := NewFetcher(srv.Client(), Config{MaxBodySize: 100})
+	html := func(mt string) bool { return mt == "text/html" }
+	ctx := context.Background()
+
+	page, err := f.FetchAccept(ctx, srv.URL+"/image", "testbot", html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.Discarded || page.Body != nil || page.ContentType != "image/png" {
+		t.Errorf("image page = discarded %v, %d bytes, %q", page.Discarded, len(page.Body), page.ContentType)
+	}
+
+	page, err = f.FetchAccept(ctx, srv.URL+"/untyped", "testbot", html)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.Sniffed || MediaType(page.ContentType) != "text/html" {
+		t.Errorf("sniffed content type = %q (sniffed %v)", page.ContentType, page.Sniffed)
+	}
+	if !page.Truncated || len(page.Body) != 100 {
+		t.Errorf("body = %d bytes, truncated %v; want 100, true", len(page.Body), page.Truncated)
+	}
+}
+
+func TestFetchIfSendsValidators(t *testing.T) {
+	const etag = `"v1"`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", http.NotFound)
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><title>v1</title></html>"))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := NewFetcher(srv.Client(), Config{})
+	page, err := f.FetchIf(context.Background(), srv.URL+"/", "testbot", Validators{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := page.Validators()
+	if page.NotModified || v.ETag != etag || v.LastModified == "" {
+		t.Fatalf("unconditional fetch: not modified %v, validators %+v", page.NotModified, v)
+	}
+
+	page, err = f.FetchIf(context.Background(), srv.URL+"/", "testbot", v, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !page.NotModified || page.StatusCode != http.StatusNotModified || page.Body != nil {
+		t.Errorf("conditional fetch: not modified %v, status %d, %d bytes", page.NotModified, page.StatusCode, len(page.Body))
+	}
+
+	if _, _, _, err := FetchDocumentIf(srv.Client(), srv.URL+"/", "testbot", v); !errors.Is(err, ErrNotModified) {
+		t.Errorf("FetchDocumentIf err = %v, want ErrNotModified", err)
+	}
+	doc, _, _, err := FetchDocumentIf(srv.Client(), srv.URL+"/", "testbot", Validators{ETag: `"v0"`})
+	if err != nil || doc.Find("title").Text() != "v1" {
+		t.Errorf("FetchDocumentIf with stale validators: err %v", err)
+	}
+
+	// without validators a 304 is still an error
+	var he *HTTPStatusError
+	mux.HandleFunc("/stale", func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusNotModified) })
+	if _, err := f.Fetch(context.Background(), srv.URL+"/stale", "testbot"); !errors.As(err, &he) {
+		t.Errorf("unconditional 304: err = %v, want *HTTPStatusError", err)
+	}
+}
@@ -0,0 +1,174 @@
+package fetch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+)
+
+// HeaderProfile adds headers to the requests sent to matching hosts. Host is
+// "example.com" for that host alone, "*.example.com" for it and its
+// subdomains, and "" or "*" for every host.
+type HeaderProfile struct {
+	Host   string
+	Header http.Header
+}
+
+func (p HeaderProfile) matches(host string) bool {
+	switch pat := strings.ToLower(p.Host); {
+	case pat == "" || pat == "*":
+		return true
+	case strings.HasPrefix(pat, "*."):
+		return host == pat[2:] || strings.HasSuffix(host, pat[1:])
+	default:
+		return host == pat
+	}
+}
+
+// specificity orders profiles so that those for narrower hosts apply last.
+func (p HeaderProfile) specificity() int {
+	switch pat := p.Host; {
+	case pat == "" || pat == "*":
+		return 0
+	case strings.HasPrefix(pat, "*."):
+		return len(pat)
+	default:
+		return 1 << 20
+	}
+}
+
+// applyHeaders sets the headers of the profiles matching u's host on h. When
+// profiles set the same header, the one for the narrower host wins, then the
+// later one.
+func applyHeaders(h http.Header, profiles []HeaderProfile, u *url.URL) {
+	host := strings.ToLower(u.Hostname())
+	var matched []HeaderProfile
+	for _, p := range profiles {
+		if p.matches(host) {
+			matched = append(matched, p)
+		}
+	}
+	slices.SortStableFunc(matched, func(a, b HeaderProfile) int { return a.specificity() - b.specificity() })
+	for _, p := range matched {
+		for name, values := range p.Header {
+			h[http.CanonicalHeaderKey(name)] = slices.Clone(values)
+		}
+	}
+}
+
+// resetHeaders removes every header the profiles could have set from h and
+// applies those matching u, for a redirect that may have changed hosts.
+func resetHeaders(h http.Header, profiles []HeaderProfile, u *url.URL) {
+	for _, p := range profiles {
+		for name := range p.Header {
+			h.Del(name)
+		}
+	}
+	applyHeaders(h, profiles, u)
+}
+
+// ParseHeader splits a "Name: value" header line.
+func ParseHeader(line string) (string, string, error) {
+	name, value, ok := strings.Cut(line, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		// the line is not echoed, as it may hold a credential
+		return "", "", errors.New("malformed header, want \"Name: value\"")
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// BasicAuth returns the Authorization header value for HTTP basic auth.
+func BasicAuth(user, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(user+":"+password))
+}
+
+// LoadHeaderProfiles reads a JSON file mapping host patterns to the headers
+// to send them, e.g.
+//
+//	{
+//	  "*": {"Accept-Language": "en"},
+//	  "intranet.example.com": {"Authorization": "Bearer s3cret"}
+//	}
+//
+// Values may also be lists, for headers sent more than once.
+func LoadHeaderProfiles(path string) ([]HeaderProfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	var profiles []HeaderProfile
+	for host, headers := range raw {
+		p := HeaderProfile{Host: host, Header: make(http.Header)}
+		for name, v := range headers {
+			var one string
+			var many []string
+			switch {
+			case json.Unmarshal(v, &one) == nil:
+				p.Header.Add(name, one)
+			case json.Unmarshal(v, &many) == nil:
+				for _, s := range many {
+					p.Header.Add(name, s)
+				}
+			default:
+				return nil, fmt.Errorf("parse %s: %s: header %s must be a string or a list of strings", path, host, name)
+			}
+		}
+		profiles = append(profiles, p)
+	}
+	// map order is random; keep the result stable for equal specificity
+	slices.SortFunc(profiles, func(a, b HeaderProfile) int { return strings.Compare(a.Host, b.Host) })
+	return profiles, nil
+}
+
+// Redacted is what secret header values are replaced with.
+const Redacted = "REDACTED"
+
+// SensitiveHeader reports whether a header carries credentials: Authorization,
+// Cookie and the like, and custom headers named like API keys or tokens.
+func SensitiveHeader(name string) bool {
+	switch http.CanonicalHeaderKey(name) {
+	case "Authorization", "Proxy-Authorization", "Cookie":
+		return true
+	}
+	name = strings.ToLower(name)
+	for _, s := range []string{"auth", "token", "key", "secret", "password", "session", "cookie", "signature"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// RedactHeader returns a copy of h with the values of sensitive headers
+// replaced, for logs and archives.
+func RedactHeader(h http.Header) http.Header {
+	out := h.Clone()
+	for name, values := range out {
+		if SensitiveHeader(name) {
+			for i := range values {
+				values[i] = Redacted
+			}
+		}
+	}
+	return out
+}
+
+// RedactHeaderLine redacts the value of a "Name: value" line if it is sensitive.
+func RedactHeaderLine(line string) string {
+	name, _, ok := strings.Cut(line, ":")
+	if ok && SensitiveHeader(strings.TrimSpace(name)) {
+		return name + ": " + Redacted
+	}
+	return line
+}
@@ -0,0 +1,114 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestApplyHeadersPrefersNarrowerHosts(t *testing.T) {
+	profiles := []HeaderProfile{
+		{Host: "shop.example.com", Header: http.Header{"X-Who": {"shop"}}},
+		{Host: "*.example.com", Header: http.Header{"X-Who": {"example"}, "X-Api-Key": {"k"}}},
+		{Header: http.Header{"X-Who": {"any"}, "Accept-Language": {"de"}}},
+	}
+	tests := []struct {
+		url, who, key string
+	}{
+		{"https://shop.example.com/", "shop", "k"},
+		{"https://www.example.com/", "example", "k"},
+		{"https://example.com/", "example", "k"},
+		{"https://notexample.com/", "any", ""},
+	}
+	for _, tc := range tests {
+		u, _ := url.Parse(tc.url)
+		h := http.Header{}
+		applyHeaders(h, profiles, u)
+		if h.Get("X-Who") != tc.who || h.Get("X-Api-Key") != tc.key || h.Get("Accept-Language") != "de" {
+			t.Errorf("%s: headers = %v", tc.url, h)
+		}
+	}
+}
+
+func TestFetchSendsHeadersPerHost(t *testing.T) {
+	got := make(map[string]http.Header)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got["other"+r.URL.Path] = r.Header.Clone()
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer other.Close()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		got["main/robots.txt"] = r.Header.Clone()
+		http.NotFound(w, r)
+	})
+	mux.HandleFunc("/away", func(w http.ResponseWriter, r *http.Request) {
+		got["main/away"] = r.Header.Clone()
+		// "localhost" is another host as far as the profiles are concerned
+		http.Redirect(w, r, strings.Replace(other.URL, "127.0.0.1", "localhost", 1)+"/page", http.StatusFound)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	f := NewFetcher(srv.Client(), Config{Headers: []HeaderProfile{
+		{Header: http.Header{"Accept-Language": {"de"}}},
+		{Host: "127.0.0.1", Header: http.Header{"Authorization": {"Bearer s3cret"}}},
+	}})
+	if _, err := f.Fetch(context.Background(), srv.URL+"/away", "testbot"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"main/robots.txt", "main/away"} {
+		if h := got[key]; h.Get("Authorization") != "Bearer s3cret" || h.Get("Accept-Language") != "de" {
+			t.Errorf("%s headers = %v", key, h)
+		}
+	}
+	if h := got["other/page"]; h == nil || h.Get("Authorization") != "" || h.Get("Accept-Language") != "de" {
+		t.Errorf("redirect target headers = %v; want Accept-Language but no Authorization", h)
+	}
+}
+
+func TestLoadHeaderProfiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "headers.json")
+	os.WriteFile(path, []byte(`{"*": {"Accept-Language": "en"}, "api.example.com": {"X-Api-Key": "k", "Accept": ["a", "b"]}}`), 0o600)
+	profiles, err := LoadHeaderProfiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse("https://api.example.com/v1")
+	h := http.Header{}
+	applyHeaders(h, profiles, u)
+	if h.Get("Accept-Language") != "en" || h.Get("X-Api-Key") != "k" || len(h.Values("Accept")) != 2 {
+		t.Errorf("headers = %v", h)
+	}
+
+	os.WriteFile(path, []byte(`{"*": {"X-Count": 1}}`), 0o600)
+	if _, err := LoadHeaderProfiles(path); err == nil {
+		t.Error("non-string header value accepted")
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	h := http.Header{"Authorization": {"Bearer x"}, "X-Api-Key": {"k"}, "Cookie": {"a=b"}, "Accept-Language": {"de"}}
+	r := RedactHeader(h)
+	for _, name := range []string{"Authorization", "X-Api-Key", "Cookie"} {
+		if r.Get(name) != Redacted {
+			t.Errorf("%s = %q, want redacted", name, r.Get(name))
+		}
+	}
+	if r.Get("Accept-Language") != "de" || h.Get("Authorization") != "Bearer x" {
+		t.Errorf("redacted %v from %v", r, h)
+	}
+	if got := RedactHeaderLine("X-Auth-Token: abc"); got != "X-Auth-Token: "+Redacted {
+		t.Errorf("RedactHeaderLine = %q", got)
+	}
+	if _, _, err := ParseHeader("Bearer abc"); err == nil || strings.Contains(err.Error(), "abc") {
+		t.Errorf("ParseHeader error = %v", err)
+	}
+}
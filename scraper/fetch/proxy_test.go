@@ -0,0 +1,149 @@
+package fetch
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProxyPoolRotation(t *testing.T) {
+	proxies := []string{"http://a:1", "http://b:1", "socks5://c:1"}
+	names := func(p *ProxyPool, hosts ...string) string {
+		var got []string
+		for _, h := range hosts {
+			got = append(got, p.pick(h).Hostname())
+		}
+		return strings.Join(got, ",")
+	}
+
+	rr, err := NewProxyPool(proxies, ProxyOptions{MaxFailures: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(rr, "x", "x", "y", "x"); got != "a,b,c,a" {
+		t.Errorf("round-robin = %s", got)
+	}
+	b := rr.proxies[1].url
+	rr.record(b, true)
+	if got := names(rr, "x", "x", "x"); got != "b,c,a" {
+		t.Errorf("after one failure = %s, want b still in rotation", got)
+	}
+	rr.record(b, true)
+	if got := names(rr, "x", "x", "x"); got != "c,a,c" {
+		t.Errorf("after ejection = %s, want b left out", got)
+	}
+	if h := rr.Health(); h[1].Ejected.IsZero() || !h[0].Ejected.IsZero() {
+		t.Errorf("health = %+v", h)
+	}
+	for _, ps := range rr.proxies {
+		ps.ejected = time.Now().Add(time.Hour)
+	}
+	rr.proxies[2].ejected = time.Now().Add(time.Minute)
+	if got := names(rr, "x"); got != "c" {
+		t.Errorf("all ejected = %s, want the one due back first", got)
+	}
+
+	sticky, err := NewProxyPool(proxies, ProxyOptions{Rotation: RotateSticky, MaxFailures: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := names(sticky, "x", "y", "x", "y", "z"); got != "a,b,a,b,c" {
+		t.Errorf("sticky = %s", got)
+	}
+	sticky.record(sticky.proxies[0].url, true)
+	if got := names(sticky, "x", "x", "y"); got != "b,b,b" {
+		t.Errorf("sticky after ejection = %s, want x moved to b", got)
+	}
+
+	for _, bad := range [][]string{nil, {"ftp://a:1"}, {"no-scheme"}} {
+		if _, err := NewProxyPool(bad, ProxyOptions{}); err == nil {
+			t.Errorf("NewProxyPool(%q) accepted", bad)
+		}
+	}
+	if _, err := NewProxyPool(proxies, ProxyOptions{Rotation: "random"}); err == nil {
+		t.Error("unknown rotation accepted")
+	}
+	if _, err := NewProxyPool([]string{"http://u:s3cret@a:1", "gopher://u:s3cret@b:1"}, ProxyOptions{}); err == nil || strings.Contains(err.Error(), "s3cret") {
+		t.Errorf("error = %v, want one without the password", err)
+	}
+}
+
+func TestFetchFailsOverToHealthyProxy(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	var auth string
+	// a forward proxy that answers for the origin itself
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen = append(seen, r.URL.String())
+		if r.URL.Path == "/page" {
+			auth = r.Header.Get("Proxy-Authorization")
+		}
+		mu.Unlock()
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<p>via proxy</p>"))
+	}))
+	defer good.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dead := "http://" + ln.Addr().String()
+	ln.Close()
+
+	goodURL := strings.Replace(good.URL, "http://", "http://user:s3cret@", 1)
+	pool, err := NewProxyPool([]string{dead, goodURL}, ProxyOptions{MaxFailures: 2, Cooldown: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: pool.Transport(), Timeout: 5 * time.Second}
+	f := NewFetcher(client, Config{
+		// proxy failures must not hold the host back for the backoff
+		Retry:   RetryPolicy{MaxRetries: 2, BaseDelay: time.Hour, BreakerThreshold: 1, BreakerCooldown: time.Hour},
+		Proxies: pool,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// robots.txt and the page are both tried through the dead proxy first
+	page, err := f.Fetch(ctx, "http://origin.test/page", "testbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(page.Body) != "<p>via proxy</p>" {
+		t.Errorf("body = %q", page.Body)
+	}
+	if u, _ := url.Parse(goodURL); page.Proxy != u.Redacted() || strings.Contains(page.Proxy, "s3cret") {
+		t.Errorf("Proxy = %q, want %q", page.Proxy, u.Redacted())
+	}
+	if want := "http://origin.test/page"; len(seen) != 2 || seen[1] != want {
+		t.Errorf("proxy saw %v, want robots.txt and %s", seen, want)
+	}
+	if auth != BasicAuth("user", "s3cret") {
+		t.Errorf("Proxy-Authorization = %q", auth)
+	}
+	if h := pool.Health(); h[0].Ejected.IsZero() {
+		t.Errorf("dead proxy not ejected: %+v", h)
+	}
+
+	// with only the dead proxy left the error names it
+	pool.proxies[1].ejected = time.Now().Add(2 * time.Hour)
+	_, err = f.Fetch(ctx, "http://origin.test/other", "testbot")
+	var pe *ProxyError
+	if !errors.As(err, &pe) || pe.Proxy != dead {
+		t.Errorf("err = %v, want a *ProxyError for %s", err, dead)
+	}
+	if hs := f.hosts["http://origin.test"]; !hs.notBefore.IsZero() || hs.failures != 0 {
+		t.Errorf("host state = %+v, want no penalty for proxy failures", hs)
+	}
+}
@@ -0,0 +1,164 @@
+package fetch
+
+import (
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetryPolicy controls how FetchRaw retries transient failures and when a
+// misbehaving host is taken out of rotation.
+type RetryPolicy struct {
+	MaxRetries       int           // retries after the first attempt
+	BaseDelay        time.Duration // first backoff interval, doubled per retry
+	MaxDelay         time.Duration // cap on backoff, Retry-After and the adaptive penalty
+	BreakerThreshold int           // consecutive failures that open a host's circuit; 0 disables
+	BreakerCooldown  time.Duration // how long an open circuit rejects requests
+}
+
+// DefaultRetryPolicy is what the package-level functions use until SetRetryPolicy is called.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxRetries:       2,
+	BaseDelay:        500 * time.Millisecond,
+	MaxDelay:         30 * time.Second,
+	BreakerThreshold: 5,
+	BreakerCooldown:  time.Minute,
+}
+
+// CircuitOpenError is returned while a host's circuit breaker is open.
+type CircuitOpenError struct {
+	Host  string
+	Until time.Time
+}
+
+func (e *CircuitOpenError) Error() string {
+	return "circuit open for " + e.Host + " until " + e.Until.Format(time.RFC3339)
+}
+
+// backoff returns the exponential backoff for a retry attempt with equal
+// jitter, i.e. a random duration in [d/2, d).
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.BaseDelay
+	for i := 0; i < attempt && (p.MaxDelay <= 0 || d < p.MaxDelay); i++ {
+		d *= 2
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	half := d / 2
+	return half + rand.N(d-half)
+}
+
+// shouldRetry classifies an attempt. Network errors and 429, 500, 502, 503 and
+// 504 responses are retried; the Retry-After delay is returned when present.
+func shouldRetry(resp *http.Response, err error) (time.Duration, bool) {
+	if err != nil {
+		var nerr net.Error
+		var opErr *net.OpError
+		switch {
+		case errors.As(err, &nerr) && nerr.Timeout():
+			return 0, true
+		case errors.As(err, &opErr):
+			return 0, true
+		case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+			return 0, true
+		}
+		return 0, false
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return parseRetryAfter(resp.Header.Get("Retry-After"), time.Now()), true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return 0, true
+	}
+	return 0, false
+}
+
+// parseRetryAfter accepts both the delay-seconds and HTTP-date forms.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+// checkBreaker rejects requests to a host whose circuit is open. Once the
+// cooldown has passed a single trial request is let through.
+func (f *Fetcher) checkBreaker(host string) error {
+	p := f.config().Retry
+	f.hostMu.Lock()
+	defer f.hostMu.Unlock()
+	hs := f.hostFor(host)
+	now := time.Now()
+	if now.Before(hs.openUntil) {
+		return &CircuitOpenError{Host: host, Until: hs.openUntil}
+	}
+	if p.BreakerThreshold > 0 && hs.failures >= p.BreakerThreshold {
+		// half-open: this request is the trial, the others wait for its outcome
+		hs.openUntil = now.Add(p.BreakerCooldown)
+	}
+	return nil
+}
+
+// recordSuccess closes the host's circuit and eases its adaptive penalty.
+func (f *Fetcher) recordSuccess(host string) {
+	f.hostMu.Lock()
+	defer f.hostMu.Unlock()
+	hs := f.hostFor(host)
+	hs.failures = 0
+	hs.openUntil = time.Time{}
+	hs.penalty /= 2
+	if hs.penalty < 10*time.Millisecond {
+		hs.penalty = 0
+	}
+}
+
+// recordFailure counts a failed attempt, opening the circuit at the threshold.
+// Throttling responses (429/503) also double the host's interval.
+func (f *Fetcher) recordFailure(host string, throttled bool) {
+	p := f.config().Retry
+	f.hostMu.Lock()
+	defer f.hostMu.Unlock()
+	hs := f.hostFor(host)
+	hs.failures++
+	if p.BreakerThreshold > 0 && hs.failures >= p.BreakerThreshold {
+		hs.openUntil = time.Now().Add(p.BreakerCooldown)
+	}
+	if throttled {
+		hs.penalty *= 2
+		if hs.penalty < p.BaseDelay {
+			hs.penalty = p.BaseDelay
+		}
+		if p.MaxDelay > 0 && hs.penalty > p.MaxDelay {
+			hs.penalty = p.MaxDelay
+		}
+	}
+}
+
+// deferHost keeps every request to host on hold for at least wait.
+func (f *Fetcher) deferHost(host string, wait time.Duration) {
+	f.hostMu.Lock()
+	defer f.hostMu.Unlock()
+	hs := f.hostFor(host)
+	if t := time.Now().Add(wait); t.After(hs.notBefore) {
+		hs.notBefore = t
+	}
+}
@@ -0,0 +1,105 @@
+package fetch
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func withRetryPolicy(t *testing.T, p RetryPolicy) {
+	t.Helper()
+	prev := std.config().Retry
+	SetRetryPolicy(p)
+	t.Cleanup(func() { SetRetryPolicy(prev) })
+}
+
+func TestFetchRawRetriesAfterServiceUnavailable(t *testing.T) {
+	withRetryPolicy(t, RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Second})
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		if hits.Add(1) == 1 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("<html>ok</html>"))
+	}))
+	defer srv.Close()
+
+	data, _, err := FetchRaw(srv.Client(), srv.URL+"/page", "testbot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "<html>ok</html>" || hits.Load() != 2 {
+		t.Errorf("got %q after %d hits, want the second response", data, hits.Load())
+	}
+}
+
+func TestFetchRawOpensCircuitAfterConsecutiveFailures(t *testing.T) {
+	withRetryPolicy(t, RetryPolicy{MaxRetries: 0, BaseDelay: time.Millisecond, BreakerThreshold: 2, BreakerCooldown: time.Minute})
+
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/robots.txt" {
+			http.NotFound(w, r)
+			return
+		}
+		hits.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer srv.Close()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := FetchRaw(srv.Client(), srv.URL+"/page", "testbot"); err == nil {
+			t.Fatal("expected an error for a 502 response")
+		}
+	}
+	_, _, err := FetchRaw(srv.Client(), srv.URL+"/page", "testbot")
+	var open *CircuitOpenError
+	if !errors.As(err, &open) {
+		t.Fatalf("third fetch error = %v, want CircuitOpenError", err)
+	}
+	if hits.Load() != 2 {
+		t.Errorf("server saw %d requests, want 2", hits.Load())
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		val  string
+		want time.Duration
+	}{
+		{"120", 2 * time.Minute},
+		{"Wed, 01 May 2024 12:00:30 GMT", 30 * time.Second},
+		{"Wed, 01 May 2024 11:00:00 GMT", 0},
+		{"soon", 0},
+		{"", 0},
+	}
+	for _, tc := range tests {
+		if got := parseRetryAfter(tc.val, now); got != tc.want {
+			t.Errorf("parseRetryAfter(%q) = %v, want %v", tc.val, got, tc.want)
+		}
+	}
+}
+
+func TestBackoffStaysWithinBounds(t *testing.T) {
+	p := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}
+	for attempt := 0; attempt < 8; attempt++ {
+		want := p.BaseDelay << attempt
+		if want > p.MaxDelay {
+			want = p.MaxDelay
+		}
+		if got := p.backoff(attempt); got < want/2 || got >= want {
+			t.Errorf("backoff(%d) = %v, want in [%v, %v)", attempt, got, want/2, want)
+		}
+	}
+}
@@ -0,0 +1,442 @@
+package fetch
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"scrawler/scraper/parse"
+)
+
+// Robots parsing and cache, following RFC 9309.
+type RobotsBlockedError struct{ URL string }
+
+func (e *RobotsBlockedError) Error() string { return "blocked by robots.txt: " + e.URL }
+
+// maxRobotsBytes is the parsing limit; RFC 9309 requires at least 500 KiB.
+const maxRobotsBytes = 500 << 10
+
+// robotsTTL bounds how long a cached robots.txt is trusted.
+const robotsTTL = 24 * time.Hour
+
+// robotsErrorTTL is how long an unreachable robots.txt, cached as disallowing
+// everything, is kept before it is requested again.
+const robotsErrorTTL = time.Minute
+
+type robotsTxt struct {
+	uaRules     map[string][]robotRule   // by lowercase product token, "*" for default
+	uaDelay     map[string]time.Duration // Crawl-delay/Request-rate per group
+	sitemaps    []string
+	disallowAll bool // robots.txt was unreachable (5xx or network error)
+	failed      bool // the request got no answer from the site, or a 407 from a proxy
+	expires     time.Time
+}
+type robotRule struct {
+	allow bool
+	path  string // normalized pattern, may contain '*' and a trailing '$'
+}
+
+// RobotsAllowed checks if URL is allowed for the given user-agent.
+func RobotsAllowed(client *http.Client, rawURL, userAgent string) bool {
+	return std.robotsAllowed(context.Background(), client, rawURL, userAgent)
+}
+
+// RobotsSitemaps returns the Sitemap URLs listed in the robots.txt of rawURL's host.
+func RobotsSitemaps(client *http.Client, rawURL, userAgent string) []string {
+	return std.robotsSitemaps(context.Background(), client, rawURL, userAgent)
+}
+
+// RobotsAllowed checks if URL is allowed for the given user-agent, using the
+// Fetcher's robots.txt cache.
+func (f *Fetcher) RobotsAllowed(ctx context.Context, rawURL, userAgent string) bool {
+	return f.robotsAllowed(ctx, f.client, rawURL, userAgent)
+}
+
+// RobotsSitemaps returns the Sitemap URLs listed in the robots.txt of rawURL's host.
+func (f *Fetcher) RobotsSitemaps(ctx context.Context, rawURL, userAgent string) []string {
+	return f.robotsSitemaps(ctx, f.client, rawURL, userAgent)
+}
+
+func (f *Fetcher) robotsAllowed(ctx context.Context, client *http.Client, rawURL, userAgent string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return true
+	}
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "" {
+		ua = "*"
+	}
+	return f.getRobots(ctx, client, u, userAgent).isAllowed(ua, u.RequestURI())
+}
+
+func (f *Fetcher) robotsSitemaps(ctx context.Context, client *http.Client, rawURL, userAgent string) []string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	return f.getRobots(ctx, client, u, userAgent).sitemaps
+}
+
+// getRobots returns the cached robots.txt for u's scheme+host, fetching it
+// when missing or expired.
+func (f *Fetcher) getRobots(ctx context.Context, client *http.Client, u *url.URL, userAgent string) *robotsTxt {
+	host := u.Scheme + "://" + u.Host
+
+	// Fast-path cache lookup without holding network calls under the lock (double-checked locking)
+	f.robotsMu.Lock()
+	rob := f.robots[host]
+	f.robotsMu.Unlock()
+	if rob == nil || time.Now().After(rob.expires) {
+		fetched := f.fetchRobots(ctx, client, u, userAgent)
+		f.robotsMu.Lock()
+		if cur := f.robots[host]; cur == nil || time.Now().After(cur.expires) {
+			f.robots[host] = fetched
+		}
+		rob = f.robots[host]
+		f.robotsMu.Unlock()
+	}
+	return rob
+}
+
+// fetchRobots fetches the robots.txt of u's host, retrying transient failures
+// under the retry policy. Through proxies, a request that fails moves on to
+// the next proxy at once, so that one broken proxy does not leave the host
+// disallowed.
+func (f *Fetcher) fetchRobots(ctx context.Context, client *http.Client, u *url.URL, userAgent string) *robotsTxt {
+	cfg := f.config()
+	policy := cfg.Retry
+	host := u.Scheme + "://" + u.Host
+	proxyTries := 0
+	if cfg.Proxies != nil {
+		proxyTries = len(cfg.Proxies.proxies)
+	}
+	for attempt := 0; ; {
+		pctx, proxy := cfg.Proxies.withProxy(ctx, u)
+		rob, retryAfter, retry := fetchRobots(pctx, client, host, userAgent, cfg.Headers)
+		if proxy != nil {
+			cfg.Proxies.record(proxy, rob.failed)
+		}
+		if !retry || ctx.Err() != nil {
+			return rob
+		}
+		if proxy != nil && rob.failed {
+			if proxyTries--; proxyTries > 0 {
+				continue
+			}
+		}
+		if attempt >= policy.MaxRetries || (policy.MaxDelay > 0 && retryAfter > policy.MaxDelay) {
+			return rob
+		}
+		wait := policy.backoff(attempt)
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		attempt++
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return rob
+		}
+	}
+}
+
+// fetchRobots requests host's robots.txt once. It reports whether the failure
+// is worth retrying, with the Retry-After delay, as shouldRetry does for pages.
+// An unreachable robots.txt disallows everything for robotsErrorTTL only.
+func fetchRobots(ctx context.Context, client *http.Client, host, userAgent string, headers []HeaderProfile) (*robotsTxt, time.Duration, bool) {
+	rob := &robotsTxt{uaRules: map[string][]robotRule{"*": {}}, expires: time.Now().Add(robotsTTL)}
+	unreachable := func() {
+		rob.disallowAll, rob.expires = true, time.Now().Add(robotsErrorTTL)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, host+"/robots.txt", nil)
+	if err != nil {
+		return rob, 0, false
+	}
+	if userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
+	}
+	applyHeaders(req.Header, headers, req.URL)
+	resp, err := client.Do(req)
+	if err != nil {
+		// unreachable: RFC 9309 2.3.1.4 requires assuming complete disallow
+		unreachable()
+		rob.failed = true
+		retryAfter, retry := shouldRetry(nil, err)
+		return rob, retryAfter, retry
+	}
+	defer resp.Body.Close()
+	retryAfter, retry := shouldRetry(resp, nil)
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		// the proxy's answer says nothing about the site
+		unreachable()
+		rob.failed = true
+		return rob, 0, true
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		// still failing after retries; a rate limit is no sign the rules are gone
+		unreachable()
+		return rob, retryAfter, retry
+	case resp.StatusCode >= 400:
+		// unavailable: crawling is unrestricted
+		return rob, retryAfter, retry
+	case resp.StatusCode >= 300:
+		// redirects the client gave up following count as unavailable
+		return rob, 0, false
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRobotsBytes))
+	if err != nil {
+		unreachable()
+		return rob, 0, true
+	}
+	parseRobots(string(data), rob)
+	return rob, 0, false
+}
+
+// parseRobots adds the groups of a robots.txt body to rob. Consecutive
+// user-agent lines share one group, and a group ends at the first user-agent
+// line that follows one of its rules.
+func parseRobots(text string, rob *robotsTxt) {
+	text = strings.TrimPrefix(text, "\uFEFF")
+	var currentUA []string
+	inRules := false
+	for _, line := range strings.Split(text, "\n") {
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		l := strings.TrimSpace(line)
+		if l == "" {
+			continue
+		}
+		parts := strings.SplitN(l, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		key := strings.ToLower(strings.TrimSpace(parts[0]))
+		val := strings.TrimSpace(parts[1])
+		switch key {
+		case "user-agent":
+			if inRules {
+				currentUA = nil
+				inRules = false
+			}
+			ua := "*"
+			if val != "*" {
+				ua = parse.ProductToken(val)
+			}
+			if ua == "" {
+				continue
+			}
+			currentUA = append(currentUA, ua)
+			if _, ok := rob.uaRules[ua]; !ok {
+				rob.uaRules[ua] = nil
+			}
+		case "allow", "disallow":
+			inRules = true
+			if val == "" {
+				// an empty rule matches nothing
+				continue
+			}
+			rule := robotRule{allow: key == "allow", path: normalizeRobotsPath(val)}
+			for _, ua := range currentUA {
+				rob.uaRules[ua] = append(rob.uaRules[ua], rule)
+			}
+		case "crawl-delay", "request-rate":
+			inRules = true
+			var d time.Duration
+			var ok bool
+			if key == "crawl-delay" {
+				d, ok = parseCrawlDelay(val)
+			} else {
+				d, ok = parseRequestRate(val)
+			}
+			if !ok {
+				continue
+			}
+			if rob.uaDelay == nil {
+				rob.uaDelay = make(map[string]time.Duration)
+			}
+			for _, ua := range currentUA {
+				if d > rob.uaDelay[ua] {
+					rob.uaDelay[ua] = d
+				}
+			}
+		case "sitemap":
+			// Sitemap lines are not tied to a user-agent group
+			if val != "" {
+				rob.sitemaps = append(rob.sitemaps, val)
+			}
+		}
+	}
+}
+
+// isAllowed applies the rules of the group matching userAgent's product token,
+// or the "*" group when none matches. The longest matching pattern wins and
+// Allow wins a tie, as RFC 9309 specifies.
+func (r *robotsTxt) isAllowed(userAgent string, path string) bool {
+	if path == "/robots.txt" {
+		return true
+	}
+	if r.disallowAll {
+		return false
+	}
+	rules := r.uaRules[r.group(userAgent)]
+	path = normalizeRobotsPath(path)
+	best := -1
+	allowed := true
+	for _, rule := range rules {
+		if !matchRobotsPattern(rule.path, path) {
+			continue
+		}
+		plen := len(rule.path)
+		if plen > best {
+			best = plen
+			allowed = rule.allow
+		} else if plen == best && rule.allow {
+			allowed = true
+		}
+	}
+	return allowed
+}
+
+// group returns the key of the group that applies to userAgent.
+func (r *robotsTxt) group(userAgent string) string {
+	if tok := parse.ProductToken(userAgent); tok != "" {
+		if _, ok := r.uaRules[tok]; ok {
+			return tok
+		}
+	}
+	return "*"
+}
+
+// crawlDelay returns the Crawl-delay or Request-rate interval of the group
+// that applies to userAgent, or 0 when it sets none.
+func (r *robotsTxt) crawlDelay(userAgent string) time.Duration {
+	return r.uaDelay[r.group(userAgent)]
+}
+
+// parseCrawlDelay parses a Crawl-delay value in (possibly fractional) seconds.
+func parseCrawlDelay(val string) (time.Duration, bool) {
+	secs, err := strconv.ParseFloat(val, 64)
+	if err != nil || secs < 0 || math.IsInf(secs, 0) || math.IsNaN(secs) {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
+
+// parseRequestRate parses a Request-rate value such as "1/5", "1/10s" or
+// "30/1m" into the interval between requests.
+func parseRequestRate(val string) (time.Duration, bool) {
+	if i := strings.IndexAny(val, " \t"); i >= 0 {
+		// an optional visit-time window follows the rate; it is not enforced
+		val = val[:i]
+	}
+	n, per, ok := strings.Cut(val, "/")
+	if !ok {
+		return 0, false
+	}
+	reqs, err := strconv.Atoi(n)
+	if err != nil || reqs <= 0 {
+		return 0, false
+	}
+	unit := time.Second
+	switch {
+	case strings.HasSuffix(per, "s"):
+		per = strings.TrimSuffix(per, "s")
+	case strings.HasSuffix(per, "m"):
+		per, unit = strings.TrimSuffix(per, "m"), time.Minute
+	case strings.HasSuffix(per, "h"):
+		per, unit = strings.TrimSuffix(per, "h"), time.Hour
+	}
+	window, err := strconv.Atoi(per)
+	if err != nil || window < 0 {
+		return 0, false
+	}
+	return time.Duration(window) * unit / time.Duration(reqs), true
+}
+
+// matchRobotsPattern matches a path against a pattern where '*' matches any
+// sequence of characters and a trailing '$' anchors the end of the path.
+func matchRobotsPattern(pattern, path string) bool {
+	anchored := strings.HasSuffix(pattern, "$")
+	if anchored {
+		pattern = pattern[:len(pattern)-1]
+	}
+	parts := strings.Split(pattern, "*")
+	if !strings.HasPrefix(path, parts[0]) {
+		return false
+	}
+	rest := path[len(parts[0]):]
+	if len(parts) == 1 {
+		return !anchored || rest == ""
+	}
+	for _, p := range parts[1 : len(parts)-1] {
+		i := strings.Index(rest, p)
+		if i < 0 {
+			return false
+		}
+		rest = rest[i+len(p):]
+	}
+	last := parts[len(parts)-1]
+	if anchored {
+		return strings.HasSuffix(rest, last)
+	}
+	return strings.Contains(rest, last)
+}
+
+// normalizeRobotsPath brings patterns and paths to one percent-encoding form:
+// escaped unreserved characters are decoded, other escapes are upper-cased and
+// non-ASCII or control octets are escaped.
+func normalizeRobotsPath(s string) string {
+	const hex = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			v := unhex(s[i+1])<<4 | unhex(s[i+2])
+			if isUnreserved(v) {
+				b.WriteByte(v)
+			} else {
+				b.WriteByte('%')
+				b.WriteByte(hex[v>>4])
+				b.WriteByte(hex[v&0x0f])
+			}
+			i += 2
+			continue
+		}
+		if c >= 0x80 || c <= 0x20 || c == 0x7f {
+			b.WriteByte('%')
+			b.WriteByte(hex[c>>4])
+			b.WriteByte(hex[c&0x0f])
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+func unhex(c byte) byte {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
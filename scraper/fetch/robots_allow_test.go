@@ -2,8 +2,8 @@ package fetch
 
 import "testing"
 
-func TestAllowOverridesDisallow_BoundaryAware(t *testing.T) {
-	// Broad disallow with a specific allowed subpath
+func TestLongerAllowOverridesDisallow(t *testing.T) {
+	// Broad disallow with a longer allowed prefix; the longest match wins
 	rob := makeRobots("User-agent: *\nDisallow: /jobs\nAllow: /jobs/api\n")
 
 	tests := []struct {
@@ -11,13 +11,13 @@ func TestAllowOverridesDisallow_BoundaryAware(t *testing.T) {
 		want bool
 	}{
 		{"/", true},
-		{"/jobs", false},            // blocked by Disallow: /jobs
-		{"/jobs/", false},           // blocked by Disallow: /jobs
-		{"/jobs/foo", false},        // blocked by Disallow: /jobs
-		{"/jobs/api", true},         // explicitly allowed
-		{"/jobs/api/", true},        // allowed subpath
-		{"/jobs/api/v1", true},      // allowed subpath
-		{"/jobs/apis", false},       // NOT a subpath of /jobs/api; remains blocked by /jobs
+		{"/jobs", false},       // blocked by Disallow: /jobs
+		{"/jobs/", false},      // blocked by Disallow: /jobs
+		{"/jobs/foo", false},   // blocked by Disallow: /jobs
+		{"/jobs/api", true},    // explicitly allowed
+		{"/jobs/api/", true},   // allowed subpath
+		{"/jobs/api/v1", true}, // allowed subpath
+		{"/jobs/apis", true},   // Allow /jobs/api is a prefix too, and the longer match
 	}
 
 	for _, tc := range tests {
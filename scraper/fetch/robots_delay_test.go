@@ -0,0 +1,65 @@
+package fetch
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRobotsCrawlDelayPerGroup(t *testing.T) {
+	rob := makeRobots(`User-agent: scrawler
+Crawl-delay: 2.5
+Disallow: /private
+
+User-agent: *
+Request-rate: 1/10s
+`)
+
+	if got := rob.crawlDelay("scrawler/0.1"); got != 2500*time.Millisecond {
+		t.Errorf("crawlDelay(scrawler) = %v, want 2.5s", got)
+	}
+	if got := rob.crawlDelay("otherbot"); got != 10*time.Second {
+		t.Errorf("crawlDelay(otherbot) = %v, want 10s", got)
+	}
+}
+
+func TestParseRequestRate(t *testing.T) {
+	tests := []struct {
+		val  string
+		want time.Duration
+		ok   bool
+	}{
+		{"1/5", 5 * time.Second, true},
+		{"1/10s", 10 * time.Second, true},
+		{"30/1m", 2 * time.Second, true},
+		{"1/1h 0600-0845", time.Hour, true},
+		{"0/5", 0, false},
+		{"fast", 0, false},
+	}
+	for _, tc := range tests {
+		got, ok := parseRequestRate(tc.val)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseRequestRate(%q) = %v, %v; want %v, %v", tc.val, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestThrottleSpacesConcurrentCallers(t *testing.T) {
+	const delay = 30 * time.Millisecond
+	host := "http://throttle.test"
+	f := NewFetcher(nil, Config{})
+	start := time.Now()
+	done := make(chan struct{})
+	for i := 0; i < 3; i++ {
+		go func() {
+			f.throttle(context.Background(), host, delay)
+			done <- struct{}{}
+		}()
+	}
+	for i := 0; i < 3; i++ {
+		<-done
+	}
+	if elapsed := time.Since(start); elapsed < 2*delay {
+		t.Errorf("three throttled requests took %v, want at least %v", elapsed, 2*delay)
+	}
+}
@@ -0,0 +1,157 @@
+package fetch
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRobotsGroupsAndProductToken(t *testing.T) {
+	rob := makeRobots(`# groups
+User-agent: ExampleBot
+User-agent: scrawler
+Disallow: /private
+
+User-agent: *
+Disallow: /tmp
+Allow: /tmp/public
+`)
+
+	tests := []struct {
+		ua   string
+		path string
+		want bool
+	}{
+		{"scrawler/0.1 (+https://example.local)", "/private/x", false},
+		{"Scrawler", "/private", false},
+		{"examplebot/2.0", "/private", false},
+		{"scrawler/0.1", "/tmp/x", true}, // the "*" group does not apply to a matched agent
+		{"otherbot/1.0", "/private", true},
+		{"otherbot/1.0", "/tmp/x", false},
+		{"otherbot/1.0", "/tmp/public", true},
+		{"*", "/private", true}, // a bot-specific group does not leak into the default group
+	}
+	for _, tc := range tests {
+		if got := rob.isAllowed(tc.ua, tc.path); got != tc.want {
+			t.Errorf("isAllowed(%q, %q) = %v, want %v", tc.ua, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRobotsWildcardsAndAnchors(t *testing.T) {
+	rob := makeRobots("User-agent: *\nDisallow: /*.pdf$\nDisallow: /search*q=\nAllow: /page$\nDisallow: /page\n")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/files/report.pdf", false},
+		{"/files/report.pdf?download=1", true},
+		{"/files/report.pdfx", true},
+		{"/search?q=go", false},
+		{"/search/advanced?lang=en&q=go", false},
+		{"/search?lang=en", true},
+		{"/page", true},
+		{"/page/2", false},
+	}
+	for _, tc := range tests {
+		if got := rob.isAllowed("*", tc.path); got != tc.want {
+			t.Errorf("isAllowed(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestRobotsPrecedenceAndEncoding(t *testing.T) {
+	rob := makeRobots("User-agent: *\nDisallow: /a\nAllow: /a\nDisallow: /%7Euser\nDisallow: /caf%c3%a9\nDisallow: /ü\n")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/a", true}, // equal length: Allow wins
+		{"/~user/home", false},
+		{"/%7euser/home", false},
+		{"/café", false},
+		{"/caf%C3%A9", false},
+		{"/%C3%BC", false},
+		{"/robots.txt", true},
+	}
+	for _, tc := range tests {
+		if got := rob.isAllowed("*", tc.path); got != tc.want {
+			t.Errorf("isAllowed(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestFetchRobotsStatusHandling(t *testing.T) {
+	tests := []struct {
+		status int
+		body   string
+		want   bool
+		ttl    time.Duration
+	}{
+		{http.StatusOK, "User-agent: *\nDisallow: /x\n", false, robotsTTL},
+		{http.StatusNotFound, "User-agent: *\nDisallow: /x\n", true, robotsTTL},
+		{http.StatusServiceUnavailable, "", false, robotsErrorTTL},
+		{http.StatusTooManyRequests, "", false, robotsErrorTTL},
+	}
+	for _, tc := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tc.status)
+			w.Write([]byte(tc.body))
+		}))
+		rob, _, _ := fetchRobots(context.Background(), srv.Client(), srv.URL, "testbot", nil)
+		if got := rob.isAllowed("testbot", "/x"); got != tc.want {
+			t.Errorf("status %d: isAllowed(/x) = %v, want %v", tc.status, got, tc.want)
+		}
+		if ttl := time.Until(rob.expires); ttl > tc.ttl || ttl < tc.ttl-time.Minute/2 {
+			t.Errorf("status %d: cached for %v, want %v", tc.status, ttl, tc.ttl)
+		}
+		srv.Close()
+	}
+}
+
+func TestFetcherRetriesRobotsAndForgetsFailuresSoon(t *testing.T) {
+	var hits atomic.Int32
+	var down atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if down.Load() || hits.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("User-agent: *\nDisallow: /x\n"))
+	}))
+	defer srv.Close()
+
+	f := NewFetcher(srv.Client(), Config{Retry: RetryPolicy{MaxRetries: 1, BaseDelay: time.Millisecond}})
+	if !f.RobotsAllowed(context.Background(), srv.URL+"/y", "testbot") || hits.Load() != 2 {
+		t.Errorf("after %d request(s), want /y allowed by the retried robots.txt", hits.Load())
+	}
+
+	down.Store(true)
+	f = NewFetcher(srv.Client(), Config{})
+	if f.RobotsAllowed(context.Background(), srv.URL+"/y", "testbot") {
+		t.Error("/y allowed while robots.txt is unreachable")
+	}
+	u, _ := url.Parse(srv.URL)
+	if rob := f.robots[u.Scheme+"://"+u.Host]; time.Until(rob.expires) > robotsErrorTTL {
+		t.Errorf("unreachable robots.txt cached until %v, want at most %v", rob.expires, robotsErrorTTL)
+	}
+}
+
+func TestFetchRobotsSizeCap(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\n" + strings.Repeat("# padding\n", maxRobotsBytes/10+1) + "Disallow: /late\n"))
+	}))
+	defer srv.Close()
+
+	rob, _, _ := fetchRobots(context.Background(), srv.Client(), srv.URL, "testbot", nil)
+	if !rob.isAllowed("testbot", "/late") {
+		t.Error("rules past the size cap should be ignored")
+	}
+}
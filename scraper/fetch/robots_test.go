@@ -8,7 +8,8 @@ func makeRobots(rules string) *robotsTxt {
 	return rob
 }
 
-func TestBoundaryMatching_DisallowJobs(t *testing.T) {
+func TestDisallowMatchesPathPrefix(t *testing.T) {
+	// Rules are plain path prefixes (RFC 9309 2.2.2), not path segments
 	rob := makeRobots("User-agent: *\nDisallow: /jobs\n")
 
 	tests := []struct {
@@ -21,8 +22,8 @@ func TestBoundaryMatching_DisallowJobs(t *testing.T) {
 		{"/jobs", false},     // exact blocked
 		{"/jobs/", false},    // subpath blocked
 		{"/jobs/foo", false}, // subpath blocked
-		{"/job", true},       // not blocked (prefix boundary)
-		{"/jobs2", true},     // not blocked (prefix boundary)
+		{"/job", true},       // shorter than the rule
+		{"/jobs2", false},    // the rule is a prefix, not a path segment
 		{"/documentation", true},
 	}
 
@@ -0,0 +1,254 @@
+package fetch
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultMaxIdleConnsPerHost is the idle pool size per host when
+// TransportConfig.MaxIdleConnsPerHost is 0; net/http keeps only 2, which
+// concurrent fetches from one host quickly outgrow.
+const DefaultMaxIdleConnsPerHost = 16
+
+// TransportConfig tunes the connections of an http.Transport. Zero values
+// keep the defaults of http.DefaultTransport, except MaxIdleConnsPerHost.
+type TransportConfig struct {
+	DialTimeout           time.Duration // TCP connect
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration // from the request written to the response headers
+	IdleConnTimeout       time.Duration // how long an idle connection is kept
+	MaxIdleConns          int           // idle connections kept over all hosts
+	MaxIdleConnsPerHost   int           // DefaultMaxIdleConnsPerHost when 0
+	MaxConnsPerHost       int           // connections per host, 0 for no limit
+	DisableHTTP2          bool
+	// CAFile is a PEM bundle of certificate authorities trusted besides the
+	// system's. InsecureSkipVerify accepts any certificate, for staging
+	// hosts only.
+	CAFile             string
+	InsecureSkipVerify bool
+	// DNSCacheTTL keeps host name lookups for this long; 0 looks up every
+	// connection. Hosts maps host names to the IP address to connect to,
+	// like /etc/hosts; the name is still used for TLS.
+	DNSCacheTTL time.Duration
+	Hosts       map[string]string
+}
+
+// NewTransport returns a copy of http.DefaultTransport tuned by cfg.
+func NewTransport(cfg TransportConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	d := &dialer{dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}}
+	if cfg.DialTimeout > 0 {
+		d.dialer.Timeout = cfg.DialTimeout
+	}
+	if len(cfg.Hosts) > 0 {
+		d.hosts = make(map[string]string, len(cfg.Hosts))
+		for host, ip := range cfg.Hosts {
+			ip = strings.Trim(ip, "[]")
+			if net.ParseIP(ip) == nil {
+				return nil, fmt.Errorf("host %s: invalid IP address %q", host, ip)
+			}
+			d.hosts[strings.ToLower(host)] = ip
+		}
+	}
+	if cfg.DNSCacheTTL > 0 {
+		d.cache = &dnsCache{ttl: cfg.DNSCacheTTL, entries: make(map[string]dnsEntry)}
+	}
+	t.DialContext = d.DialContext
+
+	if cfg.TLSHandshakeTimeout > 0 {
+		t.TLSHandshakeTimeout = cfg.TLSHandshakeTimeout
+	}
+	if cfg.ResponseHeaderTimeout > 0 {
+		t.ResponseHeaderTimeout = cfg.ResponseHeaderTimeout
+	}
+	if cfg.IdleConnTimeout > 0 {
+		t.IdleConnTimeout = cfg.IdleConnTimeout
+	}
+	if cfg.MaxIdleConns > 0 {
+		t.MaxIdleConns = cfg.MaxIdleConns
+	}
+	t.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	if cfg.MaxIdleConnsPerHost > 0 {
+		t.MaxIdleConnsPerHost = cfg.MaxIdleConnsPerHost
+	}
+	t.MaxConnsPerHost = cfg.MaxConnsPerHost
+	if cfg.DisableHTTP2 {
+		var p http.Protocols
+		p.SetHTTP1(true)
+		t.Protocols = &p
+	}
+
+	if cfg.CAFile != "" || cfg.InsecureSkipVerify {
+		tc := &tls.Config{InsecureSkipVerify: cfg.InsecureSkipVerify}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, err
+			}
+			roots, err := x509.SystemCertPool()
+			if err != nil {
+				roots = x509.NewCertPool()
+			}
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("%s: no PEM certificates found", cfg.CAFile)
+			}
+			tc.RootCAs = roots
+		}
+		t.TLSClientConfig = tc
+	}
+	return t, nil
+}
+
+// dialer connects to the address Hosts gives for a name, or to the cached
+// addresses of it, trying them in turn.
+type dialer struct {
+	dialer *net.Dialer
+	hosts  map[string]string // by lower-case host name
+	cache  *dnsCache         // nil when lookups are not cached
+}
+
+func (d *dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	if ip, ok := d.hosts[strings.ToLower(host)]; ok {
+		return d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+	}
+	if d.cache == nil || net.ParseIP(host) != nil {
+		return d.dialer.DialContext(ctx, network, addr)
+	}
+	ips, err := d.cache.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var errs []error
+	for _, ip := range ips {
+		conn, err := d.dialer.DialContext(ctx, network, net.JoinHostPort(ip, port))
+		if err == nil {
+			return conn, nil
+		}
+		errs = append(errs, err)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+type dnsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]dnsEntry
+}
+
+type dnsEntry struct {
+	ips     []string
+	expires time.Time
+}
+
+// lookup returns the addresses of host, looking it up when the cached answer
+// is missing or expired. Failed lookups are not cached.
+func (c *dnsCache) lookup(ctx context.Context, host string) ([]string, error) {
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && time.Now().Before(e.expires) {
+		return e.ips, nil
+	}
+	ips, err := net.DefaultResolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[host] = dnsEntry{ips: ips, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return ips, nil
+}
+
+// ParseHostOverride splits a "host:ip" override, e.g. "example.com:10.0.0.5"
+// or "example.com:[::1]".
+func ParseHostOverride(s string) (string, string, error) {
+	host, ip, ok := strings.Cut(s, ":")
+	ip = strings.Trim(ip, "[]")
+	if !ok || host == "" || net.ParseIP(ip) == nil {
+		return "", "", fmt.Errorf("invalid host override %q, want host:ip", s)
+	}
+	return strings.ToLower(host), ip, nil
+}
+
+// LoadTransportConfig reads a TransportConfig from a JSON file whose keys are
+// the snake_case field names, with durations written like "10s", e.g.
+//
+//	{
+//	  "dial_timeout": "5s",
+//	  "max_idle_conns_per_host": 32,
+//	  "disable_http2": true,
+//	  "hosts": {"staging.example.com": "10.0.0.5"}
+//	}
+func LoadTransportConfig(path string) (TransportConfig, error) {
+	var raw struct {
+		DialTimeout           string            `json:"dial_timeout"`
+		TLSHandshakeTimeout   string            `json:"tls_handshake_timeout"`
+		ResponseHeaderTimeout string            `json:"response_header_timeout"`
+		IdleConnTimeout       string            `json:"idle_conn_timeout"`
+		MaxIdleConns          int               `json:"max_idle_conns"`
+		MaxIdleConnsPerHost   int               `json:"max_idle_conns_per_host"`
+		MaxConnsPerHost       int               `json:"max_conns_per_host"`
+		DisableHTTP2          bool              `json:"disable_http2"`
+		CAFile                string            `json:"ca_file"`
+		InsecureSkipVerify    bool              `json:"insecure_skip_verify"`
+		DNSCacheTTL           string            `json:"dns_cache_ttl"`
+		Hosts                 map[string]string `json:"hosts"`
+	}
+	var cfg TransportConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return cfg, fmt.Errorf("parse %s: %w", path, err)
+	}
+	cfg = TransportConfig{
+		MaxIdleConns:        raw.MaxIdleConns,
+		MaxIdleConnsPerHost: raw.MaxIdleConnsPerHost,
+		MaxConnsPerHost:     raw.MaxConnsPerHost,
+		DisableHTTP2:        raw.DisableHTTP2,
+		CAFile:              raw.CAFile,
+		InsecureSkipVerify:  raw.InsecureSkipVerify,
+		Hosts:               raw.Hosts,
+	}
+	for _, d := range []struct {
+		name string
+		s    string
+		dst  *time.Duration
+	}{
+		{"dial_timeout", raw.DialTimeout, &cfg.DialTimeout},
+		{"tls_handshake_timeout", raw.TLSHandshakeTimeout, &cfg.TLSHandshakeTimeout},
+		{"response_header_timeout", raw.ResponseHeaderTimeout, &cfg.ResponseHeaderTimeout},
+		{"idle_conn_timeout", raw.IdleConnTimeout, &cfg.IdleConnTimeout},
+		{"dns_cache_ttl", raw.DNSCacheTTL, &cfg.DNSCacheTTL},
+	} {
+		if d.s == "" {
+			continue
+		}
+		if *d.dst, err = time.ParseDuration(d.s); err != nil {
+			return cfg, fmt.Errorf("parse %s: %s: %w", path, d.name, err)
+		}
+	}
+	return cfg, nil
+}
@@ -0,0 +1,95 @@
+package fetch
+
+import (
+	"encoding/pem"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewTransportTLSAndHostOverrides(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.Proto))
+	}))
+	srv.EnableHTTP2 = true
+	srv.Config.ErrorLog = log.New(io.Discard, "", 0) // the rejected handshake
+	srv.StartTLS()
+	defer srv.Close()
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600)
+	// the test certificate is issued for example.com, which must not be looked up
+	target := "https://example.com:" + srv.URL[strings.LastIndex(srv.URL, ":")+1:] + "/"
+	hosts := map[string]string{"Example.COM": "127.0.0.1"}
+
+	get := func(cfg TransportConfig) (string, error) {
+		tr, err := NewTransport(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tr.CloseIdleConnections()
+		resp, err := (&http.Client{Transport: tr, Timeout: 5 * time.Second}).Get(target)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		return resp.Proto, nil
+	}
+	if _, err := get(TransportConfig{Hosts: hosts}); err == nil {
+		t.Error("certificate of an unknown CA accepted")
+	}
+	if proto, err := get(TransportConfig{Hosts: hosts, CAFile: ca}); err != nil || proto != "HTTP/2.0" {
+		t.Errorf("with CA file: %s, %v; want HTTP/2.0", proto, err)
+	}
+	if proto, err := get(TransportConfig{Hosts: hosts, InsecureSkipVerify: true, DisableHTTP2: true}); err != nil || proto != "HTTP/1.1" {
+		t.Errorf("insecure without HTTP/2: %s, %v; want HTTP/1.1", proto, err)
+	}
+	if _, err := NewTransport(TransportConfig{Hosts: map[string]string{"example.com": "not-an-ip"}}); err == nil {
+		t.Error("invalid host override accepted")
+	}
+	if _, err := NewTransport(TransportConfig{CAFile: filepath.Join(t.TempDir(), "missing.pem")}); err == nil {
+		t.Error("missing CA file accepted")
+	}
+}
+
+func TestNewTransportDefaults(t *testing.T) {
+	tr, err := NewTransport(TransportConfig{MaxConnsPerHost: 4, ResponseHeaderTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	def := http.DefaultTransport.(*http.Transport)
+	if tr.MaxIdleConnsPerHost != DefaultMaxIdleConnsPerHost || tr.MaxConnsPerHost != 4 || tr.ResponseHeaderTimeout != time.Second ||
+		tr.MaxIdleConns != def.MaxIdleConns || tr.TLSHandshakeTimeout != def.TLSHandshakeTimeout || tr.Proxy == nil {
+		t.Errorf("transport = %+v", tr)
+	}
+}
+
+func TestLoadTransportConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transport.json")
+	os.WriteFile(path, []byte(`{"dial_timeout": "5s", "dns_cache_ttl": "1m", "max_idle_conns_per_host": 32,
+		"disable_http2": true, "hosts": {"staging.example.com": "10.0.0.5"}}`), 0o600)
+	cfg, err := LoadTransportConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DialTimeout != 5*time.Second || cfg.DNSCacheTTL != time.Minute || cfg.MaxIdleConnsPerHost != 32 ||
+		!cfg.DisableHTTP2 || cfg.Hosts["staging.example.com"] != "10.0.0.5" {
+		t.Errorf("config = %+v", cfg)
+	}
+
+	for _, bad := range []string{`{"dial_timeout": "soon"}`, `{"dial_timeout": 5}`, `{"dial_timout": "5s"}`} {
+		os.WriteFile(path, []byte(bad), 0o600)
+		if _, err := LoadTransportConfig(path); err == nil {
+			t.Errorf("%s accepted", bad)
+		}
+	}
+
+	if host, ip, err := ParseHostOverride("Example.com:[::1]"); err != nil || host != "example.com" || ip != "::1" {
+		t.Errorf("ParseHostOverride = %q, %q, %v", host, ip, err)
+	}
+}
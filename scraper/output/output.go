@@ -76,20 +76,34 @@ func SaveExtraction(baseOutDir, relDir, fileBase, format string, sig parse.Signa
 	if err := os.MkdirAll(absDir, 0o755); err != nil {
 		return err
 	}
+	target := filepath.Join(baseOutDir, filepath.FromSlash(ExtractionPath(relDir, fileBase, format)))
 	switch strings.ToLower(format) {
 	case "md", "markdown":
-		return os.WriteFile(filepath.Join(absDir, fileBase+".md"), []byte(RenderMarkdown(sig)), 0o644)
+		return os.WriteFile(target, []byte(RenderMarkdown(sig)), 0o644)
 	case "txt", "text":
-		return os.WriteFile(filepath.Join(absDir, fileBase+".txt"), []byte(RenderPlainText(sig)), 0o644)
+		return os.WriteFile(target, []byte(RenderPlainText(sig)), 0o644)
 	default:
 		data, err := RenderJSON(sig)
 		if err != nil {
 			return err
 		}
-		return os.WriteFile(filepath.Join(absDir, fileBase+".json"), data, 0o644)
+		return os.WriteFile(target, data, 0o644)
 	}
 }
 
+// ExtractionPath returns the slash-separated path, relative to the output
+// directory, that SaveExtraction writes to.
+func ExtractionPath(relDir, fileBase, format string) string {
+	ext := ".json"
+	switch strings.ToLower(format) {
+	case "md", "markdown":
+		ext = ".md"
+	case "txt", "text":
+		ext = ".txt"
+	}
+	return filepath.ToSlash(filepath.Join("extract", relDir, fileBase+ext))
+}
+
 // BuildRelativePath returns relDir and fileBase given host, path, and rawquery
 func BuildRelativePath(host, escapedPath, rawQuery string) (string, string) {
 	hostDir := sanitize(host)
@@ -0,0 +1,166 @@
+package parse
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// ToUTF8 converts an HTML body to UTF-8 and returns it with the name of the
+// charset it was in. The charset comes from a byte order mark, the
+// Content-Type header or a <meta charset>/http-equiv tag in the first 1024
+// bytes, in that order; failing those it is guessed from the content among
+// UTF-8 and the sniffCharsets. A body that cannot be decoded is returned as is.
+func ToUTF8(body []byte, contentType string) ([]byte, string) {
+	enc, name, certain := charset.DetermineEncoding(body, contentType)
+	if !certain && name == "windows-1252" && !declared(body) {
+		enc, name = sniffCharset(body)
+	}
+	if name == "utf-8" {
+		return bytes.TrimPrefix(body, utf8BOM), name
+	}
+	out, err := enc.NewDecoder().Bytes(body)
+	if err != nil {
+		return body, name
+	}
+	return bytes.TrimPrefix(out, utf8BOM), name
+}
+
+var utf8BOM = []byte("\xef\xbb\xbf")
+
+// sniffCharsets are the legacy charsets sniffCharset chooses among, the
+// default first so that it wins ties.
+var sniffCharsets = []string{"windows-1252", "windows-1251", "koi8-r", "shift_jis", "euc-jp"}
+
+// sniffLen is how much of a body sniffCharset looks at.
+const sniffLen = 64 << 10
+
+// declared reports whether DetermineEncoding took its windows-1252 answer for
+// body from a meta tag rather than falling back to it. With the non-ASCII
+// bytes of the head blanked out and UTF-8 added (twice, as the last rune is
+// dropped in case it was cut off), the fallback answers utf-8 instead while a
+// meta tag still wins.
+func declared(body []byte) bool {
+	head := body[:min(len(body), 1020)]
+	probe := make([]byte, 0, len(head)+4)
+	for _, b := range head {
+		if b >= utf8.RuneSelf {
+			b = ' '
+		}
+		probe = append(probe, b)
+	}
+	_, name, _ := charset.DetermineEncoding(append(probe, "éé"...), "")
+	return name != "utf-8"
+}
+
+// sniffCharset guesses the charset of an undeclared body: UTF-8 if it is
+// valid UTF-8, otherwise the sniffCharsets decoding that reads most like
+// text.
+func sniffCharset(body []byte) (encoding.Encoding, string) {
+	sample := body[:min(len(body), sniffLen)]
+	if valid(sample) {
+		return encoding.Nop, "utf-8"
+	}
+	var best encoding.Encoding
+	var bestName string
+	bestScore := 0
+	for _, label := range sniffCharsets {
+		enc, name := charset.Lookup(label)
+		text, err := enc.NewDecoder().Bytes(sample)
+		if err != nil {
+			continue
+		}
+		if s := textScore(text); best == nil || s > bestScore {
+			best, bestName, bestScore = enc, name, s
+		}
+	}
+	return best, bestName
+}
+
+// valid is utf8.Valid allowing for a rune cut off at the end of a sample.
+func valid(b []byte) bool {
+	for i := len(b) - 1; i >= 0 && i > len(b)-4; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				b = b[:i]
+			}
+			break
+		}
+	}
+	return utf8.Valid(b)
+}
+
+// textScore rates how plausible decoded text is by its non-ASCII runes. Pairs
+// of letters of one script count for it: an accented letter beside a plain
+// Latin one, Cyrillic letters that do not turn upper case mid-word, and CJK
+// characters, kana above all. Letters of mixed scripts, controls, symbols,
+// halfwidth katakana and undecodable bytes count against it.
+func textScore(text []byte) int {
+	score := 0
+	prev := ' '
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		text = text[size:]
+		switch {
+		case r == utf8.RuneError:
+			score -= 5
+		case r < utf8.RuneSelf && prev < utf8.RuneSelf:
+			// plain ASCII says nothing about the charset
+		case unicode.IsLetter(r) && unicode.IsLetter(prev) && !halfwidth(r) && !halfwidth(prev):
+			score += pairScore(prev, r)
+		case r >= utf8.RuneSelf && (unicode.IsControl(r) || unicode.IsSymbol(r) || halfwidth(r)):
+			score--
+		}
+		prev = r
+	}
+	return score
+}
+
+// pairScore rates two adjacent letters, at least one of them non-ASCII.
+func pairScore(a, b rune) int {
+	switch sa, sb := script(a), script(b); {
+	case sa != sb:
+		return -1
+	case sa == unicode.Latin:
+		if a < utf8.RuneSelf || b < utf8.RuneSelf {
+			return 1
+		}
+		return -1
+	case sa == unicode.Cyrillic:
+		if unicode.IsLower(a) && unicode.IsUpper(b) {
+			return -1
+		}
+		if unicode.IsLower(b) {
+			return 1
+		}
+		return 0
+	case sa == unicode.Han:
+		if unicode.In(a, unicode.Hiragana, unicode.Katakana) || unicode.In(b, unicode.Hiragana, unicode.Katakana) {
+			return 2
+		}
+		return 1
+	}
+	return 0
+}
+
+// script returns the script of a letter, with kana counted as Han.
+func script(r rune) *unicode.RangeTable {
+	switch {
+	case unicode.Is(unicode.Latin, r):
+		return unicode.Latin
+	case unicode.Is(unicode.Cyrillic, r):
+		return unicode.Cyrillic
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana) || r == 'ー':
+		return unicode.Han
+	}
+	return nil
+}
+
+// halfwidth reports a halfwidth katakana, which Shift_JIS makes of single
+// high bytes but which hardly any page uses.
+func halfwidth(r rune) bool {
+	return r >= 0xFF61 && r <= 0xFF9F
+}
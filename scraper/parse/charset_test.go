@@ -0,0 +1,74 @@
+package parse
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func encode(t *testing.T, e encoding.Encoding, s string) []byte {
+	t.Helper()
+	b, err := e.NewEncoder().Bytes([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+const (
+	russian      = "<title>Привет, мир</title><p>Это страница на русском языке.</p>"
+	japaneseText = "<title>こんにちは世界</title><p>これは日本語のページです。</p>"
+)
+
+func TestToUTF8(t *testing.T) {
+	tests := []struct {
+		name        string
+		body        []byte
+		contentType string
+		wantCharset string
+		want        string
+	}{
+		{"header", encode(t, charmap.Windows1251, "<title>Привет, мир</title>"), "text/html; charset=windows-1251", "windows-1251", "<title>Привет, мир</title>"},
+		{"meta charset", encode(t, japanese.ShiftJIS, `<meta charset="Shift_JIS"><title>こんにちは</title>`), "text/html", "shift_jis", `<meta charset="Shift_JIS"><title>こんにちは</title>`},
+		{"http-equiv", encode(t, charmap.ISO8859_1, `<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1"><p>café</p>`), "", "windows-1252", `<meta http-equiv="Content-Type" content="text/html; charset=iso-8859-1"><p>café</p>`},
+		{"bom beats header", append([]byte("\xef\xbb\xbf"), "<p>naïve</p>"...), "text/html; charset=iso-8859-1", "utf-8", "<p>naïve</p>"},
+		{"utf-16 bom", encode(t, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM), "<p>ñ</p>"), "", "utf-16le", "<p>ñ</p>"},
+		{"sniffed utf-8", []byte("<p>über</p>"), "", "utf-8", "<p>über</p>"},
+		{"sniffed legacy", []byte("<p>\xfcber</p>"), "", "windows-1252", "<p>über</p>"},
+		{"sniffed utf-8 past the head", []byte("<p>" + strings.Repeat(" ", 2000) + "über</p>"), "", "utf-8", "<p>" + strings.Repeat(" ", 2000) + "über</p>"},
+		{"sniffed windows-1251", encode(t, charmap.Windows1251, russian), "", "windows-1251", russian},
+		{"sniffed koi8-r", encode(t, charmap.KOI8R, russian), "", "koi8-r", russian},
+		{"sniffed shift_jis", encode(t, japanese.ShiftJIS, japaneseText), "", "shift_jis", japaneseText},
+		{"sniffed euc-jp", encode(t, japanese.EUCJP, japaneseText), "", "euc-jp", japaneseText},
+		{"sniffed latin", encode(t, charmap.Windows1252, "<p>Déjà vu, à la française: naïveté.</p>"), "", "windows-1252", "<p>Déjà vu, à la française: naïveté.</p>"},
+	}
+	for _, tc := range tests {
+		got, cs := ToUTF8(tc.body, tc.contentType)
+		if cs != tc.wantCharset {
+			t.Errorf("%s: charset = %q, want %q", tc.name, cs, tc.wantCharset)
+		}
+		if !bytes.Equal(got, []byte(tc.want)) {
+			t.Errorf("%s: body = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+	if _, cs := ToUTF8(encode(t, charmap.Windows1251, `<meta charset="iso-8859-1">`+russian), ""); cs != "windows-1252" {
+		t.Errorf("sniffing overrode <meta charset>: charset = %q", cs)
+	}
+}
+
+func TestExtractSignalsAfterToUTF8(t *testing.T) {
+	body := encode(t, charmap.Windows1251, `<html><head><title>Новости</title></head><body><p>Первый абзац</p></body></html>`)
+	text, _ := ToUTF8(body, "text/html; charset=windows-1251")
+	sig := ExtractSignals(mustDoc(t, string(text)), "https://example.com/")
+	if sig.Title != "Новости" {
+		t.Errorf("title = %q", sig.Title)
+	}
+	if len(sig.Paragraphs) != 1 || sig.Paragraphs[0] != "Первый абзац" {
+		t.Errorf("paragraphs = %q", sig.Paragraphs)
+	}
+}
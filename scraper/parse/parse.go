@@ -14,6 +14,12 @@ type Signals struct {
 	Headings   []string `json:"headings"`
 	Paragraphs []string `json:"paragraphs"`
 	Links      []string `json:"links"`
+	// Robots holds the page's <meta name="robots"> directives; crawls also
+	// apply X-Robots-Tag and user-agent specific tags.
+	Robots Directives `json:"robots,omitzero"`
+	// Charset is the character set the page was served in, set by callers
+	// that decoded it with ToUTF8.
+	Charset string `json:"charset,omitempty"`
 }
 
 func collapseWhitespace(s string) string {
@@ -31,6 +37,7 @@ func ExtractSignals(doc *goquery.Document, pageURL string) Signals {
 	seenParagraph := make(map[string]struct{})
 	seenLink := make(map[string]struct{})
 
+	result.Robots = RobotsDirectives(doc, nil, "")
 	result.Title = collapseWhitespace(doc.Find("title").First().Text())
 	if v, ok := doc.Find("meta[name='description']").Attr("content"); ok {
 		result.MetaDesc = collapseWhitespace(v)
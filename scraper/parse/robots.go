@@ -0,0 +1,135 @@
+package parse
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Directives are the crawler rules a page sets with <meta name="robots"> and
+// the X-Robots-Tag response header.
+type Directives struct {
+	NoIndex   bool `json:"noindex,omitempty"`
+	NoFollow  bool `json:"nofollow,omitempty"`
+	NoArchive bool `json:"noarchive,omitempty"`
+}
+
+// RobotsDirectives collects the directives that apply to userAgent: meta tags
+// named "robots" or after its product token, and X-Robots-Tag values that are
+// either unscoped or scoped to that token. doc or header may be nil.
+func RobotsDirectives(doc *goquery.Document, header http.Header, userAgent string) Directives {
+	var d Directives
+	token := ProductToken(userAgent)
+	if doc != nil {
+		doc.Find("meta[name][content]").Each(func(_ int, s *goquery.Selection) {
+			name := strings.ToLower(strings.TrimSpace(s.AttrOr("name", "")))
+			if name == "robots" || (token != "" && name == token) {
+				d.add(s.AttrOr("content", ""))
+			}
+		})
+	}
+	for _, v := range header.Values("X-Robots-Tag") {
+		// "googlebot: noindex" only applies to that crawler
+		if name, rest, ok := strings.Cut(v, ":"); ok && !strings.Contains(name, ",") && !isValuedDirective(name) {
+			if strings.ToLower(strings.TrimSpace(name)) != token {
+				continue
+			}
+			v = rest
+		}
+		d.add(v)
+	}
+	return d
+}
+
+func (d *Directives) add(list string) {
+	for _, v := range strings.Split(list, ",") {
+		switch strings.ToLower(strings.TrimSpace(v)) {
+		case "noindex":
+			d.NoIndex = true
+		case "nofollow":
+			d.NoFollow = true
+		case "noarchive", "nocache":
+			d.NoArchive = true
+		case "none":
+			d.NoIndex, d.NoFollow = true, true
+		}
+	}
+}
+
+// String lists the directives that are set, e.g. "noindex,nofollow".
+func (d Directives) String() string {
+	var out []string
+	if d.NoIndex {
+		out = append(out, "noindex")
+	}
+	if d.NoFollow {
+		out = append(out, "nofollow")
+	}
+	if d.NoArchive {
+		out = append(out, "noarchive")
+	}
+	return strings.Join(out, ",")
+}
+
+// isValuedDirective reports whether name is a directive written as "name: value"
+// rather than a user-agent scope.
+func isValuedDirective(name string) bool {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "unavailable_after", "max-snippet", "max-image-preview", "max-video-preview":
+		return true
+	}
+	return false
+}
+
+// ProductToken returns the lower-cased product token of a User-Agent: its
+// leading letters, underscores and hyphens, as RFC 9309 matches robots.txt
+// groups, e.g. "scrawler" for "Scrawler/0.1 (+https://example.local)". "*"
+// is returned as it is.
+func ProductToken(userAgent string) string {
+	ua := strings.ToLower(strings.TrimSpace(userAgent))
+	if ua == "*" {
+		return ua
+	}
+	end := 0
+	for end < len(ua) {
+		c := ua[end]
+		if (c >= 'a' && c <= 'z') || c == '_' || c == '-' {
+			end++
+			continue
+		}
+		break
+	}
+	return ua[:end]
+}
+
+// Link is an <a href> of a document, resolved against the page URL.
+type Link struct {
+	URL      *url.URL
+	NoFollow bool // rel="nofollow"
+}
+
+// Links returns the links of a document in order, without fragments.
+func Links(doc *goquery.Document, base *url.URL) []Link {
+	var out []Link
+	doc.Find("a[href]").Each(func(_ int, s *goquery.Selection) {
+		href, ok := s.Attr("href")
+		if !ok || href == "" {
+			return
+		}
+		u, err := base.Parse(href)
+		if err != nil {
+			return
+		}
+		u.Fragment = ""
+		link := Link{URL: u}
+		for _, rel := range strings.Fields(s.AttrOr("rel", "")) {
+			if strings.EqualFold(rel, "nofollow") {
+				link.NoFollow = true
+			}
+		}
+		out = append(out, link)
+	})
+	return out
+}
@@ -0,0 +1,71 @@
+package parse
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+func mustDoc(t *testing.T, html string) *goquery.Document {
+	t.Helper()
+	doc, err := goquery.NewDocumentFromReader(strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestRobotsDirectives(t *testing.T) {
+	tests := []struct {
+		name   string
+		html   string
+		header []string
+		want   string
+	}{
+		{"meta robots", `<meta name="Robots" content="NoIndex, nofollow">`, nil, "noindex,nofollow"},
+		{"meta none", `<meta name="robots" content="none">`, nil, "noindex,nofollow"},
+		{"own token", `<meta name="scrawler" content="noarchive"><meta name="googlebot" content="noindex">`, nil, "noarchive"},
+		{"header", ``, []string{"noindex", "nocache"}, "noindex,noarchive"},
+		{"scoped header", ``, []string{"otherbot: noindex", "scrawler: nofollow"}, "nofollow"},
+		{"valued directive", ``, []string{"unavailable_after: 25 Jun 2010 15:00:00 PST", "max-snippet: 0, noarchive"}, "noarchive"},
+	}
+	for _, tc := range tests {
+		h := http.Header{}
+		for _, v := range tc.header {
+			h.Add("X-Robots-Tag", v)
+		}
+		got := RobotsDirectives(mustDoc(t, "<html><head>"+tc.html+"</head></html>"), h, "scrawler/0.1 (+https://example.local)")
+		if got.String() != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestRobotsDirectivesMatchTokenAsRobotsTxt(t *testing.T) {
+	// robots.txt groups match "scrawler" for this agent, so its meta tags do too
+	doc := mustDoc(t, `<meta name="scrawler" content="noindex"><meta name="scrawler2" content="nofollow">`)
+	if got := RobotsDirectives(doc, nil, "Scrawler2 (+https://example.local)"); got.String() != "noindex" {
+		t.Errorf("got %q, want noindex", got)
+	}
+	if tok := ProductToken("Googlebot-Image/1.0"); tok != "googlebot-image" {
+		t.Errorf("ProductToken = %q", tok)
+	}
+}
+
+func TestLinksMarksNoFollow(t *testing.T) {
+	doc := mustDoc(t, `<a href="/a#x">a</a> <a rel="external NoFollow" href="b">b</a>`)
+	base, _ := url.Parse("https://example.com/dir/")
+	links := Links(doc, base)
+	if len(links) != 2 {
+		t.Fatalf("got %d links, want 2", len(links))
+	}
+	if links[0].URL.String() != "https://example.com/a" || links[0].NoFollow {
+		t.Errorf("links[0] = %+v", links[0])
+	}
+	if links[1].URL.String() != "https://example.com/dir/b" || !links[1].NoFollow {
+		t.Errorf("links[1] = %+v", links[1])
+	}
+}
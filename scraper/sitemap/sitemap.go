@@ -0,0 +1,162 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"scrawler/scraper/fetch"
+)
+
+// maxSitemapBytes is the uncompressed size limit from the sitemaps.org protocol.
+const maxSitemapBytes = 50 << 20
+
+// maxSitemaps bounds how many sitemap files a single discovery will fetch.
+const maxSitemaps = 1000
+
+// Entry is a single <url> of a urlset sitemap.
+type Entry struct {
+	Loc      string
+	LastMod  time.Time // zero when absent or unparseable
+	Priority float64   // 0.5 when absent, as the protocol specifies
+}
+
+type entryXML struct {
+	Loc      string `xml:"loc"`
+	LastMod  string `xml:"lastmod"`
+	Priority string `xml:"priority"`
+}
+
+// document matches both <urlset> and <sitemapindex> files.
+type document struct {
+	XMLName  xml.Name
+	URLs     []entryXML `xml:"url"`
+	Sitemaps []entryXML `xml:"sitemap"`
+}
+
+// Discover collects sitemap entries for the site of startURL. Sitemaps listed
+// in robots.txt are used, falling back to /sitemap.xml; sitemap indexes are
+// followed and gzip-compressed sitemaps are decompressed. When since is not
+// zero, entries (and child sitemaps) with a <lastmod> before it are dropped.
+// Sitemaps are fetched through f, so they count against its per-host delay.
+func Discover(ctx context.Context, f *fetch.Fetcher, startURL, userAgent string, since time.Time) ([]Entry, error) {
+	start, err := url.Parse(startURL)
+	if err != nil {
+		return nil, err
+	}
+	roots := f.RobotsSitemaps(ctx, startURL, userAgent)
+	if len(roots) == 0 {
+		roots = []string{start.Scheme + "://" + start.Host + "/sitemap.xml"}
+	}
+
+	var entries []Entry
+	seenLoc := make(map[string]struct{})
+	seenMap := make(map[string]struct{})
+	pending := roots
+	var firstErr error
+	for len(pending) > 0 && len(seenMap) < maxSitemaps {
+		loc := pending[0]
+		pending = pending[1:]
+		if _, ok := seenMap[loc]; ok {
+			continue
+		}
+		seenMap[loc] = struct{}{}
+
+		doc, err := fetchSitemap(ctx, f, loc, userAgent)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		for _, sm := range doc.Sitemaps {
+			child := strings.TrimSpace(sm.Loc)
+			if child == "" || before(parseLastMod(sm.LastMod), since) {
+				continue
+			}
+			pending = append(pending, child)
+		}
+		for _, ux := range doc.URLs {
+			e := toEntry(ux)
+			if e.Loc == "" || before(e.LastMod, since) {
+				continue
+			}
+			if _, ok := seenLoc[e.Loc]; ok {
+				continue
+			}
+			seenLoc[e.Loc] = struct{}{}
+			entries = append(entries, e)
+		}
+	}
+	if len(entries) == 0 && firstErr != nil {
+		return nil, firstErr
+	}
+	return entries, nil
+}
+
+// fetchSitemap downloads and parses one sitemap or sitemap index file.
+func fetchSitemap(ctx context.Context, f *fetch.Fetcher, loc, userAgent string) (*document, error) {
+	// sitemaps may be larger than the pages the fetcher's body limit is for
+	page, err := f.FetchLimit(ctx, loc, userAgent, maxSitemapBytes)
+	if err != nil {
+		return nil, err
+	}
+	if page.Truncated {
+		return nil, fmt.Errorf("%s: sitemap larger than %d bytes", loc, maxSitemapBytes)
+	}
+	return parseSitemap(page.Body)
+}
+
+// parseSitemap decodes a sitemap, transparently gunzipping .xml.gz payloads.
+func parseSitemap(data []byte) (*document, error) {
+	var r io.Reader = bytes.NewReader(data)
+	if len(data) >= 2 && data[0] == 0x1f && data[1] == 0x8b {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	var doc document
+	if err := xml.NewDecoder(io.LimitReader(r, maxSitemapBytes)).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("parse sitemap: %w", err)
+	}
+	switch doc.XMLName.Local {
+	case "urlset", "sitemapindex":
+		return &doc, nil
+	default:
+		return nil, fmt.Errorf("parse sitemap: unexpected root element <%s>", doc.XMLName.Local)
+	}
+}
+
+func toEntry(ux entryXML) Entry {
+	e := Entry{Loc: strings.TrimSpace(ux.Loc), LastMod: parseLastMod(ux.LastMod), Priority: 0.5}
+	if p, err := strconv.ParseFloat(strings.TrimSpace(ux.Priority), 64); err == nil && p >= 0 && p <= 1 {
+		e.Priority = p
+	}
+	return e
+}
+
+// parseLastMod accepts the W3C Datetime profiles used by sitemaps.
+func parseLastMod(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04Z07:00", "2006-01-02T15:04:05", "2006-01-02", "2006-01", "2006"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// before reports whether a known lastmod falls before the since cutoff.
+func before(lastMod, since time.Time) bool {
+	return !since.IsZero() && !lastMod.IsZero() && lastMod.Before(since)
+}
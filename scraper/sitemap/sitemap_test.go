@@ -0,0 +1,97 @@
+package sitemap
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"scrawler/scraper/fetch"
+)
+
+func gzipBytes(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestDiscoverFollowsIndexAndGzip(t *testing.T) {
+	var srv *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/robots.txt", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("User-agent: *\nDisallow:\nSitemap: " + srv.URL + "/index.xml\n"))
+	})
+	mux.HandleFunc("/index.xml", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?>
+<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <sitemap><loc>` + srv.URL + `/pages.xml.gz</loc></sitemap>
+  <sitemap><loc>` + srv.URL + `/old.xml</loc><lastmod>2019-05-01</lastmod></sitemap>
+</sitemapindex>`))
+	})
+	mux.HandleFunc("/pages.xml.gz", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-gzip")
+		w.Write(gzipBytes(t, `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>`+srv.URL+`/a</loc><lastmod>2024-03-01T10:00:00+00:00</lastmod><priority>0.9</priority></url>
+  <url><loc>`+srv.URL+`/b</loc><lastmod>2020-01-01</lastmod></url>
+  <url><loc>`+srv.URL+`/c</loc></url>
+</urlset>`))
+	})
+	mux.HandleFunc("/old.xml", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("sitemap older than the cutoff should not be fetched")
+	})
+	srv = httptest.NewServer(mux)
+	defer srv.Close()
+
+	since := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := fetch.NewFetcher(srv.Client(), fetch.Config{})
+	entries, err := Discover(context.Background(), f, srv.URL+"/", "testbot", since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2: %+v", len(entries), entries)
+	}
+	if entries[0].Loc != srv.URL+"/a" || entries[0].Priority != 0.9 {
+		t.Errorf("entries[0] = %+v", entries[0])
+	}
+	if entries[1].Loc != srv.URL+"/c" || entries[1].Priority != 0.5 {
+		t.Errorf("entries[1] = %+v", entries[1])
+	}
+}
+
+func TestDiscoverIgnoresPageBodyLimit(t *testing.T) {
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/sitemap.xml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">
+  <url><loc>` + srv.URL + `/a</loc></url>
+  <url><loc>` + srv.URL + `/b</loc></url>
+</urlset>`))
+	}))
+	defer srv.Close()
+
+	f := fetch.NewFetcher(srv.Client(), fetch.Config{MaxBodySize: 64})
+	entries, err := Discover(context.Background(), f, srv.URL+"/", "testbot", time.Time{})
+	if err != nil || len(entries) != 2 {
+		t.Errorf("got %+v, %v; want both entries of a sitemap past the page size limit", entries, err)
+	}
+}
+
+func TestParseSitemapRejectsUnknownRoot(t *testing.T) {
+	if _, err := parseSitemap([]byte("<html><body>not a sitemap</body></html>")); err == nil {
+		t.Error("expected an error for a non-sitemap document")
+	}
+}
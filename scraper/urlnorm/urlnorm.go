@@ -0,0 +1,202 @@
+// Package urlnorm maps URLs to canonical keys, so that spellings of the same
+// page are recognised as one.
+package urlnorm
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Normalizer maps a URL to the key used to recognise duplicates.
+type Normalizer interface {
+	Normalize(u *url.URL) string
+}
+
+// TrackingParams are common analytics parameters that never change the page.
+var TrackingParams = []string{"utm_*", "fbclid", "gclid", "dclid", "gbraid", "wbraid", "msclkid", "yclid", "mc_cid", "mc_eid", "_ga", "_gl"}
+
+// Rules is the standard Normalizer. Whatever the rules, the scheme and host are
+// lower-cased, default ports, fragments and dot segments are removed and
+// percent-encoding is normalized.
+type Rules struct {
+	SortQuery     bool     // order query parameters by name
+	StripParams   []string // drop these query parameters; a trailing * matches a prefix
+	LowercasePath bool     // fold the path, for case-insensitive servers
+	IndexFiles    []string // final segments equivalent to their directory, e.g. index.html
+	TrailingSlash string   // "add" or "strip" on non-root paths; "" leaves paths alone
+}
+
+// Default is the normalization used unless configured otherwise.
+var Default = Rules{
+	SortQuery:   true,
+	StripParams: TrackingParams,
+	IndexFiles:  []string{"index.html", "index.htm", "index.php"},
+}
+
+// Normalize returns the canonical form of u.
+func (r Rules) Normalize(u *url.URL) string {
+	if u.Opaque != "" || u.Host == "" {
+		clone := *u
+		clone.Fragment, clone.RawFragment = "", ""
+		return clone.String()
+	}
+	scheme := strings.ToLower(u.Scheme)
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port := u.Port(); port != "" && !(scheme == "http" && port == "80") && !(scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+
+	p := u.EscapedPath()
+	if r.LowercasePath {
+		p = strings.ToLower(p)
+	}
+	p = removeDotSegments(normalizeEscapes(p))
+	if seg := p[strings.LastIndex(p, "/")+1:]; seg != "" {
+		for _, idx := range r.IndexFiles {
+			if strings.EqualFold(seg, idx) {
+				p = p[:len(p)-len(seg)]
+				break
+			}
+		}
+	}
+	switch r.TrailingSlash {
+	case "add":
+		if seg := p[strings.LastIndex(p, "/")+1:]; seg != "" && !strings.Contains(seg, ".") {
+			p += "/"
+		}
+	case "strip":
+		if len(p) > 1 {
+			p = strings.TrimSuffix(p, "/")
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString(scheme + "://")
+	if u.User != nil {
+		b.WriteString(u.User.String() + "@")
+	}
+	b.WriteString(host + p)
+	if q := r.query(u.RawQuery); q != "" {
+		b.WriteString("?" + q)
+	}
+	return b.String()
+}
+
+// query normalizes a raw query string, dropping stripped and empty parameters.
+func (r Rules) query(raw string) string {
+	if raw == "" {
+		return ""
+	}
+	var kept []string
+	for _, param := range strings.Split(raw, "&") {
+		if param == "" {
+			continue
+		}
+		param = normalizeEscapes(param)
+		if r.strip(paramName(param)) {
+			continue
+		}
+		kept = append(kept, param)
+	}
+	if r.SortQuery {
+		// stable, so repeated parameters keep their order
+		sort.SliceStable(kept, func(i, j int) bool { return paramName(kept[i]) < paramName(kept[j]) })
+	}
+	return strings.Join(kept, "&")
+}
+
+func (r Rules) strip(name string) bool {
+	if n, err := url.QueryUnescape(name); err == nil {
+		name = n
+	}
+	name = strings.ToLower(name)
+	for _, s := range r.StripParams {
+		s = strings.ToLower(s)
+		if prefix, ok := strings.CutSuffix(s, "*"); ok {
+			if strings.HasPrefix(name, prefix) {
+				return true
+			}
+		} else if name == s {
+			return true
+		}
+	}
+	return false
+}
+
+func paramName(param string) string {
+	name, _, _ := strings.Cut(param, "=")
+	return name
+}
+
+// removeDotSegments resolves "." and ".." path segments (RFC 3986, 5.2.4).
+func removeDotSegments(p string) string {
+	if p == "" {
+		return "/"
+	}
+	if !strings.HasPrefix(p, "/") || !strings.Contains(p, ".") {
+		return p
+	}
+	in := strings.Split(p[1:], "/")
+	out := make([]string, 0, len(in))
+	for i, seg := range in {
+		last := i == len(in)-1
+		switch seg {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, seg)
+			continue
+		}
+		if last {
+			out = append(out, "")
+		}
+	}
+	return "/" + strings.Join(out, "/")
+}
+
+// normalizeEscapes decodes escaped unreserved characters and upper-cases the
+// hex digits of the remaining escapes (RFC 3986, 6.2.2).
+func normalizeEscapes(s string) string {
+	if !strings.Contains(s, "%") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			if c := unhex(s[i+1])<<4 | unhex(s[i+2]); isUnreserved(c) {
+				b.WriteByte(c)
+			} else {
+				b.WriteString("%" + strings.ToUpper(s[i+1:i+3]))
+			}
+			i += 2
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
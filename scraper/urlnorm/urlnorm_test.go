@@ -0,0 +1,55 @@
+package urlnorm
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDefaultNormalize(t *testing.T) {
+	tests := []struct{ in, want string }{
+		{"HTTP://Example.COM:80/a#frag", "http://example.com/a"},
+		{"https://example.com:443", "https://example.com/"},
+		{"https://example.com:8443/", "https://example.com:8443/"},
+		{"https://example.com/docs/index.html", "https://example.com/docs/"},
+		{"https://example.com/a/./b/../c", "https://example.com/a/c"},
+		{"https://example.com/a/b/..", "https://example.com/a/"},
+		{"https://example.com/%7euser/%2fx%c3%a9", "https://example.com/~user/%2Fx%C3%A9"},
+		{"https://example.com/p?b=2&a=1&utm_source=x&a=0&fbclid=abc", "https://example.com/p?a=1&a=0&b=2"},
+		{"https://example.com/p?utm_medium=mail", "https://example.com/p"},
+		{"https://example.com/p?&&q=go", "https://example.com/p?q=go"},
+		{"mailto:someone@example.com", "mailto:someone@example.com"},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := Default.Normalize(u); got != tc.want {
+			t.Errorf("Normalize(%s) = %s, want %s", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestRulesOptions(t *testing.T) {
+	tests := []struct {
+		rules   Rules
+		in, out string
+	}{
+		{Rules{}, "https://example.com/p?b=1&a=2&utm_x=1", "https://example.com/p?b=1&a=2&utm_x=1"},
+		{Rules{StripParams: []string{"sessionid"}}, "https://example.com/p?SessionID=1&x=2", "https://example.com/p?x=2"},
+		{Rules{LowercasePath: true}, "https://example.com/Docs/%2f", "https://example.com/docs/%2F"},
+		{Rules{TrailingSlash: "add"}, "https://example.com/docs", "https://example.com/docs/"},
+		{Rules{TrailingSlash: "add"}, "https://example.com/a.pdf", "https://example.com/a.pdf"},
+		{Rules{TrailingSlash: "strip"}, "https://example.com/docs/", "https://example.com/docs"},
+		{Rules{TrailingSlash: "strip"}, "https://example.com/", "https://example.com/"},
+	}
+	for _, tc := range tests {
+		u, err := url.Parse(tc.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := tc.rules.Normalize(u); got != tc.out {
+			t.Errorf("%+v.Normalize(%s) = %s, want %s", tc.rules, tc.in, got, tc.out)
+		}
+	}
+}
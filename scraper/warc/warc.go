@@ -0,0 +1,299 @@
+package warc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Version is the WARC format version written by this package (ISO 28500:2017).
+const Version = "WARC/1.1"
+
+// DefaultMaxSize is the customary 1 GB rotation threshold.
+const DefaultMaxSize = 1 << 30
+
+// Field is a single named header field; order is preserved when written.
+type Field struct {
+	Name  string
+	Value string
+}
+
+// Exchange is one HTTP request/response pair to archive.
+type Exchange struct {
+	TargetURI  string
+	Date       time.Time
+	Request    *http.Request
+	Proto      string // of both request and response, e.g. "HTTP/2.0"
+	Status     string // e.g. "200 OK"
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Truncated marks a Body cut off at a size limit. The response record
+	// gets WARC-Truncated: length and a Content-Length header is rewritten
+	// to the length kept.
+	Truncated bool
+	// Metadata, when not empty, is written as a metadata record referring to
+	// the response (e.g. via, hopsFromSeed, fetchTimeMs).
+	Metadata []Field
+}
+
+// Writer writes gzip-per-record WARC files into a directory, starting a new
+// file with its own warcinfo record once MaxSize is exceeded.
+type Writer struct {
+	dir     string
+	prefix  string
+	maxSize int64
+	info    []Field
+
+	mu     sync.Mutex
+	f      *os.File
+	name   string
+	size   int64
+	seq    int
+	infoID string
+}
+
+// NewWriter returns a Writer creating files named
+// <prefix>-<timestamp>-<seq>.warc.gz in dir. info fields go into the warcinfo
+// record at the start of every file.
+func NewWriter(dir, prefix string, maxSize int64, info []Field) (*Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	if prefix == "" {
+		prefix = "scrawler"
+	}
+	return &Writer{dir: dir, prefix: prefix, maxSize: maxSize, info: info}, nil
+}
+
+// WriteExchange appends request, response and optional metadata records and
+// returns the file name and offset of the response record.
+func (w *Writer) WriteExchange(ex Exchange) (string, int64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil || w.size >= w.maxSize {
+		if err := w.rotate(); err != nil {
+			return "", 0, err
+		}
+	}
+	date := ex.Date.UTC().Format(time.RFC3339)
+	respID := newRecordID()
+
+	reqBlock := requestBlock(ex)
+	if len(reqBlock) > 0 {
+		if _, err := w.writeRecord([]Field{
+			{"WARC-Type", "request"},
+			{"WARC-Record-ID", newRecordID()},
+			{"WARC-Date", date},
+			{"WARC-Target-URI", ex.TargetURI},
+			{"WARC-Concurrent-To", respID},
+			{"WARC-Warcinfo-ID", w.infoID},
+			{"Content-Type", "application/http;msgtype=request"},
+		}, reqBlock); err != nil {
+			return "", 0, err
+		}
+	}
+
+	offset, err := w.writeRecord([]Field{
+		{"WARC-Type", "response"},
+		{"WARC-Record-ID", respID},
+		{"WARC-Date", date},
+		{"WARC-Target-URI", ex.TargetURI},
+		{"WARC-Warcinfo-ID", w.infoID},
+		{"WARC-Payload-Digest", digest(ex.Body)},
+		{"WARC-Truncated", truncated(ex)},
+		{"Content-Type", "application/http;msgtype=response"},
+	}, responseBlock(ex))
+	if err != nil {
+		return "", 0, err
+	}
+
+	if len(ex.Metadata) > 0 {
+		if _, err := w.writeRecord([]Field{
+			{"WARC-Type", "metadata"},
+			{"WARC-Record-ID", newRecordID()},
+			{"WARC-Date", date},
+			{"WARC-Target-URI", ex.TargetURI},
+			{"WARC-Refers-To", respID},
+			{"WARC-Warcinfo-ID", w.infoID},
+			{"Content-Type", "application/warc-fields"},
+		}, fieldsBlock(ex.Metadata)); err != nil {
+			return "", 0, err
+		}
+	}
+	return w.name, offset, nil
+}
+
+// Close finishes the current file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.f == nil {
+		return nil
+	}
+	err := w.f.Close()
+	w.f = nil
+	return err
+}
+
+// rotate closes the current file and opens the next one, starting it with a
+// warcinfo record; w.mu must be held.
+func (w *Writer) rotate() error {
+	if w.f != nil {
+		if err := w.f.Close(); err != nil {
+			return err
+		}
+		w.f = nil
+	}
+	name := fmt.Sprintf("%s-%s-%05d.warc.gz", w.prefix, time.Now().UTC().Format("20060102150405"), w.seq)
+	f, err := os.OpenFile(filepath.Join(w.dir, name), os.O_CREATE|os.O_WRONLY|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	w.seq++
+	w.f, w.name, w.size = f, name, 0
+	w.infoID = newRecordID()
+	fields := append([]Field{{"format", "WARC File Format 1.1"}, {"conformsTo", "http://iipc.github.io/warc-specifications/specifications/warc-format/warc-1.1/"}}, w.info...)
+	_, err = w.writeRecord([]Field{
+		{"WARC-Type", "warcinfo"},
+		{"WARC-Record-ID", w.infoID},
+		{"WARC-Date", time.Now().UTC().Format(time.RFC3339)},
+		{"WARC-Filename", name},
+		{"Content-Type", "application/warc-fields"},
+	}, fieldsBlock(fields))
+	return err
+}
+
+// writeRecord writes one record as its own gzip member and returns its offset.
+func (w *Writer) writeRecord(header []Field, block []byte) (int64, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	var hb strings.Builder
+	hb.WriteString(Version + "\r\n")
+	for _, f := range header {
+		if f.Value == "" {
+			continue
+		}
+		hb.WriteString(f.Name + ": " + f.Value + "\r\n")
+	}
+	hb.WriteString("WARC-Block-Digest: " + digest(block) + "\r\n")
+	hb.WriteString("Content-Length: " + strconv.Itoa(len(block)) + "\r\n\r\n")
+	zw.Write([]byte(hb.String()))
+	zw.Write(block)
+	zw.Write([]byte("\r\n\r\n"))
+	if err := zw.Close(); err != nil {
+		return 0, err
+	}
+	offset := w.size
+	n, err := w.f.Write(buf.Bytes())
+	w.size += int64(n)
+	return offset, err
+}
+
+func requestBlock(ex Exchange) []byte {
+	req := ex.Request
+	if req == nil || req.URL == nil {
+		return nil
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%s %s %s\r\n", req.Method, req.URL.RequestURI(), proto(ex))
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	b.WriteString("Host: " + host + "\r\n")
+	h := req.Header.Clone()
+	if h.Get("Accept-Encoding") == "" {
+		// added by net/http's transport unless compression is disabled
+		h.Set("Accept-Encoding", "gzip")
+	}
+	writeHeader(&b, h)
+	b.WriteString("\r\n")
+	return b.Bytes()
+}
+
+func responseBlock(ex Exchange) []byte {
+	var b bytes.Buffer
+	status := ex.Status
+	if status == "" {
+		status = strconv.Itoa(ex.StatusCode) + " " + http.StatusText(ex.StatusCode)
+	}
+	b.WriteString(proto(ex) + " " + status + "\r\n")
+	h := ex.Header
+	if ex.Truncated && h.Get("Content-Length") != "" {
+		// the block must parse as a whole message without the cut-off part
+		h = h.Clone()
+		h.Set("Content-Length", strconv.Itoa(len(ex.Body)))
+	}
+	writeHeader(&b, h)
+	b.WriteString("\r\n")
+	b.Write(ex.Body)
+	return b.Bytes()
+}
+
+// proto returns the protocol the exchange was made in, HTTP/1.1 if unknown.
+func proto(ex Exchange) string {
+	if ex.Proto == "" {
+		return "HTTP/1.1"
+	}
+	return ex.Proto
+}
+
+// truncated returns the WARC-Truncated reason of the response record, empty
+// when the body is complete.
+func truncated(ex Exchange) string {
+	if ex.Truncated {
+		return "length"
+	}
+	return ""
+}
+
+func writeHeader(b *bytes.Buffer, h http.Header) {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range h[k] {
+			b.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+}
+
+func fieldsBlock(fields []Field) []byte {
+	var b bytes.Buffer
+	for _, f := range fields {
+		b.WriteString(f.Name + ": " + f.Value + "\r\n")
+	}
+	return b.Bytes()
+}
+
+// digest returns the customary base32 SHA-1 labelled digest.
+func digest(b []byte) string {
+	sum := sha1.Sum(b)
+	return "sha1:" + base32.StdEncoding.EncodeToString(sum[:])
+}
+
+// newRecordID returns a random (version 4) UUID URN.
+func newRecordID() string {
+	var u [16]byte
+	_, _ = rand.Read(u[:])
+	u[6] = (u[6] & 0x0f) | 0x40
+	u[8] = (u[8] & 0x3f) | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
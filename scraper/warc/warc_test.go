@@ -0,0 +1,216 @@
+package warc
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readRecords splits a WARC file into its records' header maps and blocks.
+func readRecords(t *testing.T, path string) ([]map[string]string, [][]byte) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(zr)
+	var headers []map[string]string
+	var blocks [][]byte
+	for {
+		line, err := br.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != Version+"\r\n" {
+			t.Fatalf("record starts with %q", line)
+		}
+		h := make(map[string]string)
+		for {
+			line, err = br.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if line == "\r\n" {
+				break
+			}
+			name, value, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ": ")
+			h[name] = value
+		}
+		n, _ := strconv.Atoi(h["Content-Length"])
+		block := make([]byte, n+4)
+		if _, err := io.ReadFull(br, block); err != nil {
+			t.Fatal(err)
+		}
+		if string(block[n:]) != "\r\n\r\n" {
+			t.Fatalf("record not terminated by CRLFCRLF: %q", block[n:])
+		}
+		headers = append(headers, h)
+		blocks = append(blocks, block[:n])
+	}
+	return headers, blocks
+}
+
+func testExchange() Exchange {
+	req, _ := http.NewRequest(http.MethodGet, "https://example.com/a?b=1", nil)
+	req.Header.Set("User-Agent", "testbot")
+	return Exchange{
+		TargetURI:  "https://example.com/a?b=1",
+		Date:       time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Request:    req,
+		Proto:      "HTTP/1.1",
+		Status:     "200 OK",
+		StatusCode: 200,
+		Header:     http.Header{"Content-Type": {"text/html"}},
+		Body:       []byte("<html>hello</html>"),
+		Metadata:   []Field{{Name: "via", Value: "https://example.com/"}},
+	}
+}
+
+func TestWriteExchangeRecords(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "test", 0, []Field{{Name: "software", Value: "scrawler"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, offset, err := w.WriteExchange(testExchange())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, blocks := readRecords(t, filepath.Join(dir, file))
+	types := []string{"warcinfo", "request", "response", "metadata"}
+	if len(headers) != len(types) {
+		t.Fatalf("got %d records, want %d", len(headers), len(types))
+	}
+	for i, typ := range types {
+		if headers[i]["WARC-Type"] != typ {
+			t.Errorf("record %d type = %q, want %q", i, headers[i]["WARC-Type"], typ)
+		}
+	}
+	if headers[1]["WARC-Concurrent-To"] != headers[2]["WARC-Record-ID"] || headers[3]["WARC-Refers-To"] != headers[2]["WARC-Record-ID"] {
+		t.Error("request and metadata records should point at the response record")
+	}
+	if !strings.HasPrefix(string(blocks[1]), "GET /a?b=1 HTTP/1.1\r\nHost: example.com\r\n") {
+		t.Errorf("request block = %q", blocks[1])
+	}
+	if !strings.HasPrefix(string(blocks[2]), "HTTP/1.1 200 OK\r\nContent-Type: text/html\r\n\r\n<html>hello</html>") {
+		t.Errorf("response block = %q", blocks[2])
+	}
+	if headers[2]["WARC-Payload-Digest"] != digest([]byte("<html>hello</html>")) {
+		t.Errorf("payload digest = %q", headers[2]["WARC-Payload-Digest"])
+	}
+
+	// the returned offset must point at the start of the response's gzip member
+	data, _ := os.ReadFile(filepath.Join(dir, file))
+	zr, err := gzip.NewReader(bytes.NewReader(data[offset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	zr.Multistream(false)
+	member, _ := io.ReadAll(zr)
+	if !strings.Contains(string(member), "WARC-Type: response\r\n") {
+		t.Errorf("member at offset %d is not the response record", offset)
+	}
+}
+
+func TestWriteExchangeKeepsProtocol(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "test", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex := testExchange()
+	ex.Proto = "HTTP/2.0"
+	file, _, err := w.WriteExchange(ex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	_, blocks := readRecords(t, filepath.Join(dir, file))
+	if !strings.HasPrefix(string(blocks[1]), "GET /a?b=1 HTTP/2.0\r\n") {
+		t.Errorf("request block = %q", blocks[1])
+	}
+	if !strings.HasPrefix(string(blocks[2]), "HTTP/2.0 200 OK\r\n") {
+		t.Errorf("response block = %q", blocks[2])
+	}
+}
+
+func TestWriteExchangeMarksTruncatedBodies(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "test", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex := testExchange()
+	ex.Header = http.Header{"Content-Type": {"text/html"}, "Content-Length": {"1000"}}
+	ex.Truncated = true
+	file, _, err := w.WriteExchange(ex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ex = testExchange()
+	ex.Header.Set("Content-Length", "18")
+	if _, _, err := w.WriteExchange(ex); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	headers, blocks := readRecords(t, filepath.Join(dir, file))
+	if headers[2]["WARC-Truncated"] != "length" {
+		t.Errorf("truncated response WARC-Truncated = %q, want length", headers[2]["WARC-Truncated"])
+	}
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(blocks[2])), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil || string(body) != "<html>hello</html>" {
+		t.Errorf("truncated response block reads as %q, %v", body, err)
+	}
+	if _, ok := headers[5]["WARC-Truncated"]; ok || !strings.Contains(string(blocks[5]), "Content-Length: 18\r\n") {
+		t.Errorf("complete response = %v\n%s", headers[5], blocks[5])
+	}
+}
+
+func TestWriterRotatesBySize(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(dir, "test", 1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _, err := w.WriteExchange(testExchange())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := w.WriteExchange(testExchange())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if first == second {
+		t.Fatal("expected the second exchange to go to a new file")
+	}
+	headers, _ := readRecords(t, filepath.Join(dir, second))
+	if headers[0]["WARC-Type"] != "warcinfo" || headers[0]["WARC-Filename"] != second {
+		t.Errorf("rotated file should start with its own warcinfo, got %+v", headers[0])
+	}
+}